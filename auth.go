@@ -0,0 +1,255 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// How requests to NSO are authenticated:
+//
+//   basic    user and password on every request (the default)
+//   bearer   an 'Authorization: Bearer' token from nso.token
+//   session  log in once with the user and password, then reuse the session cookie (and
+//            X-Auth-Token, if NSO is configured to return one)
+//
+// Every request to NSO (the resources read at startup, HA role checks, opening a stream)
+// goes through do(), where a 401 response makes the auth method refresh its credentials
+// (re-reading the token, or logging in again) and the request is retried once. Streams
+// are only authenticated as they are opened, so one already open keeps its original
+// credentials
+
+type AuthMode int
+
+const (
+	AUTH_BASIC AuthMode = iota
+	AUTH_BEARER
+	AUTH_SESSION
+)
+
+func (m AuthMode) String() string {
+	return [...]string{"basic", "bearer", "session"}[m]
+}
+
+func parseAuthMode(s string) (AuthMode, error) {
+	switch strings.ToLower(s) {
+	case "", "basic":
+		return AUTH_BASIC, nil
+	case "bearer", "token":
+		return AUTH_BEARER, nil
+	case "session", "cookie":
+		return AUTH_SESSION, nil
+	}
+	return AUTH_BASIC, fmt.Errorf("unknown NSO auth '%s' (expected basic, bearer or session)", s)
+}
+
+const (
+	headerAuthToken = "X-Auth-Token"
+	requestURLLogin = "/data/ietf-restconf-monitoring:restconf-state"
+)
+
+type nsoAuth interface {
+	authorize(req *http.Request)
+	reauthenticate() error
+}
+
+func (s *NsoServer) newAuth(mode AuthMode) nsoAuth {
+	switch mode {
+	case AUTH_BEARER:
+		return &bearerAuth{token: s.target.token, tokenRef: s.target.tokenRef}
+	case AUTH_SESSION:
+		jar := newSessionJar()
+		s.client.Jar = jar
+		return &sessionAuth{server: s, jar: jar}
+	}
+	return &basicAuth{user: s.user, password: s.password}
+}
+
+// Issue a request to NSO, re-authenticating and retrying once if it's rejected. The NSO
+// requests carry no body, so they can safely be sent twice. The retry starts from a copy
+// taken up front, since the client adds the (stale) cookies to the request it sends
+
+func (s *NsoServer) do(req *http.Request) (*http.Response, error) {
+	retry := req.Clone(req.Context())
+	s.auth.authorize(req)
+	resp, err := s.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	_ = resp.Body.Close()
+
+	logger.Info("(NSOServer:do) request unauthorized, re-authenticating", "url", req.URL.String(), "auth", s.target.auth.String())
+	if err := s.auth.reauthenticate(); err != nil {
+		return nil, fmt.Errorf("(NSOServer:do) re-authentication failed: %v", err)
+	}
+
+	s.auth.authorize(retry)
+	return s.client.Do(retry)
+}
+
+//**********
+// Basic
+//**********
+
+type basicAuth struct {
+	user     string
+	password string
+}
+
+func (a *basicAuth) authorize(req *http.Request) {
+	req.SetBasicAuth(a.user, a.password)
+}
+
+// Nothing to refresh, the retry will simply fail again
+
+func (a *basicAuth) reauthenticate() error {
+	return nil
+}
+
+//**********
+// Bearer token
+//**********
+
+type bearerAuth struct {
+	mu       sync.RWMutex
+	token    string
+	tokenRef string
+}
+
+func (a *bearerAuth) authorize(req *http.Request) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	req.Header.Set("Authorization", "Bearer "+a.token)
+}
+
+// The token may have been rotated, so resolve the reference again (exec: in particular
+// can fetch a fresh one)
+
+func (a *bearerAuth) reauthenticate() error {
+	token, err := resolveSecret(a.tokenRef)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.token = token
+	a.mu.Unlock()
+	return nil
+}
+
+//**********
+// Session
+//**********
+
+type sessionAuth struct {
+	server   *NsoServer
+	jar      *sessionJar
+	mu       sync.RWMutex
+	loggedIn bool
+	token    string
+}
+
+func (a *sessionAuth) authorize(req *http.Request) {
+	a.mu.RLock()
+	loggedIn, token := a.loggedIn, a.token
+	a.mu.RUnlock()
+
+	if !loggedIn {
+		if err := a.reauthenticate(); err != nil {
+			logger.Error("(NSOServer:session) login failed", "url", a.server.currentNode().apiUrl, "err", err)
+			return
+		}
+		a.mu.RLock()
+		token = a.token
+		a.mu.RUnlock()
+	}
+	if token != "" {
+		req.Header.Set(headerAuthToken, token)
+	}
+}
+
+// Log in with the user and password. NSO answers with a session cookie (kept by the
+// client's jar) and/or an X-Auth-Token header. The login needs a resource that requires
+// authentication; the root resource (host-meta) doesn't, and may not be known yet
+
+func (a *sessionAuth) reauthenticate() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	apiUrl, root := a.server.connection()
+	if root == "" {
+		root = "/restconf"
+	}
+	loginUrl, err := url.Parse(apiUrl + root + requestURLLogin)
+	if err != nil {
+		return err
+	}
+
+	// Start from a clean jar so a stale session cookie isn't sent with the login
+	a.jar.reset()
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.server.target.connectTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", loginUrl.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(a.server.user, a.server.password)
+
+	resp, err := a.server.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("login returned HTTP %d", resp.StatusCode)
+	}
+
+	a.token = resp.Header.Get(headerAuthToken)
+	if a.token == "" && len(a.jar.Cookies(loginUrl)) == 0 {
+		return fmt.Errorf("server returned neither a session cookie nor an %s header", headerAuthToken)
+	}
+	a.loggedIn = true
+	logger.Debug("(NSOServer:session) logged in", "url", apiUrl)
+	return nil
+}
+
+// A cookie jar that can be emptied while requests are using it
+
+type sessionJar struct {
+	mu  sync.RWMutex
+	jar *cookiejar.Jar
+}
+
+func newSessionJar() *sessionJar {
+	j := new(sessionJar)
+	j.reset()
+	return j
+}
+
+func (j *sessionJar) reset() {
+	jar, _ := cookiejar.New(nil)
+	j.mu.Lock()
+	j.jar = jar
+	j.mu.Unlock()
+}
+
+func (j *sessionJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	j.jar.SetCookies(u, cookies)
+}
+
+func (j *sessionJar) Cookies(u *url.URL) []*http.Cookie {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.jar.Cookies(u)
+}
@@ -0,0 +1,205 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const checkpointSaveInterval = 10 * time.Second
+
+// Checkpoints record the time of the most recent event processed on each stream. With a
+// checkpoint file configured they are saved periodically and at shutdown, and streams that
+// support replay resume from their checkpoint on the next start.
+//
+// Events finish out of order (unordered mode, coalescing, a circuit breaker's queue), so
+// each event is marked as it arrives and the checkpoint only moves past an event once it
+// and every event that arrived before it on the stream are done: delivered to all their
+// webhooks, or given up on. Delivery is at-least-once: an event processed right before a
+// crash, or held back behind a slower one, may be replayed
+
+type checkpointStore struct {
+	mu      sync.Mutex
+	streams map[string]time.Time
+	pending map[string][]*checkpointMark // by stream, in arrival order
+	dirty   bool
+}
+
+// An event being processed. Each outstanding delivery of the event holds it
+
+type checkpointMark struct {
+	stream string
+	time   time.Time
+	holds  int
+}
+
+// The marks travel with the subscriber passed along the delivery path. A coalesced
+// delivery carries the marks of all the events it merges
+
+type eventMarks []*checkpointMark
+
+var checkpoints = &checkpointStore{streams: make(map[string]time.Time), pending: make(map[string][]*checkpointMark)}
+
+func (c *checkpointStore) load() error {
+	if Config().checkpointFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(Config().checkpointFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := json.Unmarshal(data, &c.streams); err != nil {
+		return err
+	}
+	c.migrate(Config().nsoTargets)
+	return nil
+}
+
+// Files saved before checkpoints were keyed by server hold bare stream names, which can
+// only have come from a single server. Called with the lock held
+
+func (c *checkpointStore) migrate(targets []*nsoInfo) {
+	for key, t := range c.streams {
+		if strings.Contains(key, "/") {
+			continue
+		}
+		if len(targets) != 1 {
+			logger.Warn("checkpoint without a server name ignored, there are several servers", "stream", key)
+			continue
+		}
+		if _, found := c.streams[targets[0].name+"/"+key]; !found {
+			c.streams[targets[0].name+"/"+key] = t
+		}
+		delete(c.streams, key)
+		c.dirty = true
+	}
+}
+
+func (c *checkpointStore) save() error {
+	if Config().checkpointFile == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(c.streams, "", "  ")
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash mid-write can't leave a truncated file
+
+	tmp := Config().checkpointFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, Config().checkpointFile)
+}
+
+// Mark an event as it arrives, held once for the caller
+
+func (c *checkpointStore) begin(stream string, eventTime time.Time) eventMarks {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := &checkpointMark{stream: stream, time: eventTime, holds: 1}
+	c.pending[stream] = append(c.pending[stream], m)
+	return eventMarks{m}
+}
+
+func (marks eventMarks) hold() {
+	checkpoints.mu.Lock()
+	defer checkpoints.mu.Unlock()
+	for _, m := range marks {
+		m.holds++
+	}
+}
+
+// Let go of the events, moving each stream's checkpoint past the finished events at the
+// head of its queue
+
+func (marks eventMarks) release() {
+	c := checkpoints
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range marks {
+		if m.holds--; m.holds > 0 {
+			continue
+		}
+		queue := c.pending[m.stream]
+		for len(queue) > 0 && queue[0].holds == 0 {
+			if queue[0].time.After(c.streams[m.stream]) {
+				c.streams[m.stream] = queue[0].time
+				c.dirty = true
+			}
+			queue[0] = nil
+			queue = queue[1:]
+		}
+		if len(queue) == 0 {
+			delete(c.pending, m.stream)
+		} else {
+			c.pending[m.stream] = queue
+		}
+	}
+}
+
+func (c *checkpointStore) get(stream string) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, found := c.streams[stream]
+	return t, found
+}
+
+// Save periodically until done
+
+func (c *checkpointStore) saveEvery(interval time.Duration, done <-chan struct{}) {
+	if Config().checkpointFile == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.save(); err != nil {
+				logger.Error("saving checkpoints", "file", Config().checkpointFile, "err", err)
+			}
+		}
+	}
+}
+
+// The stream URL to subscribe to, asking NSO to replay from the stream's checkpoint if it
+// has one and the stream supports replay
+
+func (sub streamSubscriber) replayURL() *url.URL {
+	start, found := checkpoints.get(sub.stream.checkpointKey())
+	if !found || !sub.stream.ReplaySupport {
+		return sub.url
+	}
+	replayUrl := *sub.url
+	query := replayUrl.Query()
+	query.Set("start-time", start.Format(time.RFC3339Nano))
+	replayUrl.RawQuery = query.Encode()
+	logger.Info("replaying from checkpoint", "stream", sub.stream.label(), "start-time", start.Format(time.RFC3339Nano))
+	return &replayUrl
+}
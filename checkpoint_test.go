@@ -0,0 +1,67 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// The checkpoint only moves past events once everything before them on the stream is done
+
+func TestCheckpointLowWaterMark(t *testing.T) {
+	const stream = "test/low-water"
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	checkpointAt := func(want time.Time) {
+		t.Helper()
+		got, found := checkpoints.get(stream)
+		if want.IsZero() && found || !want.IsZero() && !got.Equal(want) {
+			t.Errorf("checkpoint %v (found %v), want %v", got, found, want)
+		}
+	}
+
+	checkpoints.mu.Lock()
+	delete(checkpoints.streams, stream) // left behind by an earlier run with -count
+	checkpoints.mu.Unlock()
+
+	first := checkpoints.begin(stream, start)
+	second := checkpoints.begin(stream, start.Add(time.Second))
+	third := checkpoints.begin(stream, start.Add(2*time.Second))
+
+	second.hold() // say, queued behind a circuit breaker
+	second.release()
+	third.release()
+	checkpointAt(time.Time{})
+
+	first.release()
+	checkpointAt(start)
+
+	second.release()
+	checkpointAt(start.Add(2 * time.Second))
+
+	checkpoints.mu.Lock()
+	defer checkpoints.mu.Unlock()
+	if len(checkpoints.pending[stream]) != 0 {
+		t.Errorf("%d events still pending", len(checkpoints.pending[stream]))
+	}
+}
+
+// Checkpoints saved by stream name alone belong to the only server
+
+func TestCheckpointMigrate(t *testing.T) {
+	saved := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	c := &checkpointStore{streams: map[string]time.Time{"ncs-events": saved, "lab/ncs-alarms": saved}}
+	c.migrate([]*nsoInfo{{name: "lab"}})
+
+	for _, key := range []string{"lab/ncs-events", "lab/ncs-alarms"} {
+		if got, found := c.streams[key]; !found || !got.Equal(saved) {
+			t.Errorf("checkpoint %s = %v (found %v), want %v", key, got, found, saved)
+		}
+	}
+	if _, found := c.streams["ncs-events"]; found || len(c.streams) != 2 || !c.dirty {
+		t.Errorf("checkpoints not migrated: %v", c.streams)
+	}
+}
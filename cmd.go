@@ -8,9 +8,9 @@ package main
 import (
 	"fmt"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
-	"net/http"
-	_ "net/http/pprof"
+	"strings"
 )
 
 func newBaseCmd() *cobra.Command {
@@ -27,37 +27,58 @@ func newBaseCmd() *cobra.Command {
 			return processConfig()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			server, err := mainStartup()
+			servers, err := mainStartup()
 			if err != nil {
 				return err
 			}
-			_ = server.startSubscribers()
+			if err := servers.startSubscribers(); err != nil {
+				logger.Error("subscribing failed", "err", err)
+				return err
+			}
 			return nil
 		},
 	}
 
 	// Global flags across all commands
 
-	baseCmd.PersistentFlags().BoolP("verbose", "v", false, "enable verbose logging")
-	_ = viper.BindPFlag("verbose", baseCmd.PersistentFlags().Lookup("verbose"))
-	baseCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output")
-	_ = viper.BindPFlag("debug", baseCmd.PersistentFlags().Lookup("debug"))
+	baseCmd.PersistentFlags().StringVar(&configFile, "config", "", "config file (default ./"+defaultConfigFile+".yaml or $HOME/."+programName+"/"+defaultConfigFile+".yaml)")
+	baseCmd.PersistentFlags().String("configDir", "", "directory of extra config files (default conf.d next to the config file)")
+	bindFlag("configDir", baseCmd.PersistentFlags().Lookup("configDir"))
+
+	baseCmd.PersistentFlags().BoolP("verbose", "v", false, "enable verbose logging (same as --logLevel trace)")
+	bindFlag("verbose", baseCmd.PersistentFlags().Lookup("verbose"))
+	baseCmd.PersistentFlags().BoolP("debug", "d", false, "enable debug output (same as --logLevel debug)")
+	bindFlag("debug", baseCmd.PersistentFlags().Lookup("debug"))
+	baseCmd.PersistentFlags().String("logLevel", "info", "log level (trace, debug, info, warn, error)")
+	bindFlag("log.level", baseCmd.PersistentFlags().Lookup("logLevel"))
+	baseCmd.PersistentFlags().String("logFormat", logFormatConsole, "log format (console, json)")
+	bindFlag("log.format", baseCmd.PersistentFlags().Lookup("logFormat"))
 	baseCmd.PersistentFlags().BoolP("nocolor", "", false, "disable colorized output")
-	_ = viper.BindPFlag("nocolor", baseCmd.PersistentFlags().Lookup("nocolor"))
+	bindFlag("nocolor", baseCmd.PersistentFlags().Lookup("nocolor"))
 
 	baseCmd.PersistentFlags().Int("pprofPort", 0, "listen port for pprof server")
-	_ = viper.BindPFlag("pprofPort", baseCmd.PersistentFlags().Lookup("pprofPort"))
+	bindFlag("pprofPort", baseCmd.PersistentFlags().Lookup("pprofPort"))
+	baseCmd.PersistentFlags().Int("metricsPort", 0, "listen port for Prometheus /metrics (may match pprofPort)")
+	bindFlag("metricsPort", baseCmd.PersistentFlags().Lookup("metricsPort"))
+	baseCmd.PersistentFlags().Int("healthPort", 0, "listen port for /healthz and /readyz (may match other ports)")
+	bindFlag("healthPort", baseCmd.PersistentFlags().Lookup("healthPort"))
 
 	baseCmd.PersistentFlags().StringP("user", "u", defaultNSOUser, "user for NSO API")
-	_ = viper.BindPFlag("nso.user", baseCmd.PersistentFlags().Lookup("user"))
+	bindFlag("nso.user", baseCmd.PersistentFlags().Lookup("user"))
 	baseCmd.PersistentFlags().StringP("password", "p", defaultNSOPassword, "password for NSO API")
-	_ = viper.BindPFlag("nso.password", baseCmd.PersistentFlags().Lookup("password"))
+	bindFlag("nso.password", baseCmd.PersistentFlags().Lookup("password"))
+
+	baseCmd.PersistentFlags().String("auth", "basic", "NSO API authentication (basic, bearer, session)")
+	bindFlag("nso.auth", baseCmd.PersistentFlags().Lookup("auth"))
+
+	baseCmd.PersistentFlags().StringSlice("server", nil, "NSO server(s) to use, by name, when the config defines several")
+	bindFlag("server", baseCmd.PersistentFlags().Lookup("server"))
 
 	baseCmd.PersistentFlags().String("url", "", "NSO API URL (http://IP:PORT)")
-	_ = viper.BindPFlag("nso.restconfAPI", baseCmd.PersistentFlags().Lookup("url"))
+	bindFlag("nso.restconfAPI", baseCmd.PersistentFlags().Lookup("url"))
 
 	baseCmd.PersistentFlags().DurationP("timeout", "t", defaultReadTime, "API timeout")
-	_ = viper.BindPFlag("nso.readTimeout", baseCmd.PersistentFlags().Lookup("timeout"))
+	bindFlag("nso.readTimeout", baseCmd.PersistentFlags().Lookup("timeout"))
 
 	// Subcommands
 
@@ -69,12 +90,14 @@ func newBaseCmd() *cobra.Command {
 			return processConfig()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			server, err := mainStartup()
+			servers, err := mainStartup()
 			if err != nil {
 				return err
 			}
-			server.printStreamList()
-			return nil
+			return servers.each(func(s *NsoServer) error {
+				s.printStreamList()
+				return nil
+			})
 		},
 	}
 
@@ -85,12 +108,14 @@ func newBaseCmd() *cobra.Command {
 			return processConfig()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			server, err := mainStartup()
+			servers, err := mainStartup()
 			if err != nil {
 				return err
 			}
-			server.printState()
-			return nil
+			return servers.each(func(s *NsoServer) error {
+				s.printState()
+				return nil
+			})
 		},
 	}
 
@@ -102,17 +127,19 @@ func newBaseCmd() *cobra.Command {
 			return processConfig()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			server, err := mainStartup()
+			servers, err := mainStartup()
 			if err != nil {
 				return err
 			}
-			server.printModelList()
-			return nil
+			return servers.each(func(s *NsoServer) error {
+				s.printModelList()
+				return nil
+			})
 		},
 	}
 
 	cmdInfoModels.PersistentFlags().BoolP("mounts", "m", false, "show mount detail")
-	_ = viper.BindPFlag("mounts", cmdInfoModels.PersistentFlags().Lookup("mounts"))
+	bindFlag("mounts", cmdInfoModels.PersistentFlags().Lookup("mounts"))
 
 	cmdInfo.AddCommand(cmdInfoModels)
 
@@ -124,12 +151,14 @@ func newBaseCmd() *cobra.Command {
 			return processConfig()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			server, err := mainStartup()
+			servers, err := mainStartup()
 			if err != nil {
 				return err
 			}
-			server.printDatastoreList()
-			return nil
+			return servers.each(func(s *NsoServer) error {
+				s.printDatastoreList()
+				return nil
+			})
 		},
 	}
 	cmdInfo.AddCommand(cmdInfoDatastores)
@@ -142,12 +171,14 @@ func newBaseCmd() *cobra.Command {
 			return processConfig()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			server, err := mainStartup()
+			servers, err := mainStartup()
 			if err != nil {
 				return err
 			}
-			server.printCallpoints()
-			return nil
+			return servers.each(func(s *NsoServer) error {
+				s.printCallpoints()
+				return nil
+			})
 		},
 	}
 	cmdInfo.AddCommand(cmdInfoCallpoints)
@@ -160,12 +191,14 @@ func newBaseCmd() *cobra.Command {
 			return processConfig()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			server, err := mainStartup()
+			servers, err := mainStartup()
 			if err != nil {
 				return err
 			}
-			server.printActionpoints()
-			return nil
+			return servers.each(func(s *NsoServer) error {
+				s.printActionpoints()
+				return nil
+			})
 		},
 	}
 	cmdInfo.AddCommand(cmdInfoActionpoints)
@@ -178,15 +211,13 @@ func newBaseCmd() *cobra.Command {
 			return processConfig()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			server, err := mainStartup()
-			if err != nil {
-				return err
-			}
-			err = server.printAPIData(args[0])
+			servers, err := mainStartup()
 			if err != nil {
 				return err
 			}
-			return nil
+			return servers.each(func(s *NsoServer) error {
+				return s.printAPIData(args[0])
+			})
 		},
 	}
 	cmdInfo.AddCommand(cmdInfoAPI)
@@ -199,59 +230,212 @@ func newBaseCmd() *cobra.Command {
 			return processConfig()
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			server, err := mainStartup()
+			servers, err := mainStartup()
 			if err != nil {
 				return err
 			}
-			fmt.Printf(stringColorize("### NSO event streams from %s\n", COLOR_HIGHLIGHT), Config.nsoTarget.apiUrl)
-			server.validateWebhooks()
-			if Config.debug {
-				Config.webhooks.print()
+			for _, s := range servers {
+				logger.Info(stringColorize("### NSO event streams", COLOR_HIGHLIGHT), "server", s.name, "url", s.apiUrl)
+			}
+			servers.validateWebhooks()
+			if debugEnabled() {
+				Config().webhooks.print()
+			}
+			if err := servers.startSubscribers(); err != nil {
+				logger.Error("subscribing failed", "err", err)
+				return err
 			}
-			_ = server.startSubscribers()
 			return nil
 		},
 	}
 
 	cmdSubscribe.PersistentFlags().StringSliceP("stream", "s", nil, "stream(s) to subscribe to")
-	_ = viper.BindPFlag("stream", cmdSubscribe.PersistentFlags().Lookup("stream"))
+	bindFlag("stream", cmdSubscribe.PersistentFlags().Lookup("stream"))
+	cmdSubscribe.PersistentFlags().String("ordering", "none", "preserve event order per key (none, device, stream)")
+	bindFlag("ordering", cmdSubscribe.PersistentFlags().Lookup("ordering"))
+	cmdSubscribe.PersistentFlags().Duration("keepaliveThreshold", defaultKeepaliveThreshold, "max time without stream activity before /readyz fails (0 = no check)")
+	bindFlag("keepaliveThreshold", cmdSubscribe.PersistentFlags().Lookup("keepaliveThreshold"))
+	cmdSubscribe.PersistentFlags().Duration("shutdownGrace", defaultShutdownGrace, "time allowed to drain in-flight webhooks on shutdown")
+	bindFlag("shutdownGrace", cmdSubscribe.PersistentFlags().Lookup("shutdownGrace"))
+	cmdSubscribe.PersistentFlags().String("checkpointFile", "", "file to save per-stream replay checkpoints in")
+	bindFlag("checkpointFile", cmdSubscribe.PersistentFlags().Lookup("checkpointFile"))
+	cmdSubscribe.PersistentFlags().Bool("watchConfig", false, "reload webhooks when the config file changes (SIGHUP always reloads)")
+	bindFlag("watchConfig", cmdSubscribe.PersistentFlags().Lookup("watchConfig"))
+	cmdSubscribe.PersistentFlags().String("record", "", "append every received event to a recording (JSON lines) for replay")
+	bindFlag("recordFile", cmdSubscribe.PersistentFlags().Lookup("record"))
+	cmdSubscribe.PersistentFlags().Bool("dry-run", false, "show which webhooks would fire, and why, without sending them")
+	bindFlag("dryRun", cmdSubscribe.PersistentFlags().Lookup("dry-run"))
+
+	cmdReplay := &cobra.Command{
+		Use:   "replay <recording>",
+		Short: "send recorded events through the handlers and webhooks",
+		Args:  cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			// dryRun is bound to subscribe's flag; bind it to this one before the config is read
+
+			bindFlag("dryRun", cmd.Flags().Lookup("dry-run"))
+			return processConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			speed, _ := cmd.Flags().GetFloat64("speed")
+			if speed < 0 {
+				return fmt.Errorf("invalid --speed %v", speed)
+			}
+			return replayRecording(args[0], speed)
+		},
+	}
+
+	cmdReplay.Flags().Float64("speed", 1, "replay speed relative to the recording (2 = twice as fast, 0 = no gaps)")
+	cmdReplay.Flags().Bool("dry-run", false, "show which webhooks would fire, and why, without sending them")
+
+	cmdConfig := &cobra.Command{
+		Use:   "config",
+		Short: "check or show the configuration",
+	}
+
+	cmdConfigValidate := &cobra.Command{
+		Use:     "validate",
+		Aliases: []string{"check"},
+		Short:   "check the config files without connecting to NSO",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			problems := validateConfig()
+			for _, p := range problems {
+				fmt.Println(stringColorize(p.String(), COLOR_ERROR))
+			}
+			if count := len(problems); count > 0 {
+				return fmt.Errorf("%d config problem%s found", count, pluralSuffix(count))
+			}
+			fmt.Printf("%s is valid (%d file%s)\n", configFileName(), len(configSources), pluralSuffix(len(configSources)))
+			return nil
+		},
+	}
+	cmdConfig.AddCommand(cmdConfigValidate)
+
+	cmdConfigShow := &cobra.Command{
+		Use:   "show",
+		Short: "show the effective configuration and where each value came from",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return processConfig()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			showConfig()
+		},
+	}
+	cmdConfig.AddCommand(cmdConfigShow)
+
+	cmdFilter := &cobra.Command{
+		Use:   "filter",
+		Short: "work with webhook filters",
+	}
+
+	cmdFilterTest := &cobra.Command{
+		Use:   "test",
+		Short: "check webhook filters against sample events, without NSO",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return processConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			hookNumber, _ := cmd.Flags().GetInt("webhook")
+			streamName, _ := cmd.Flags().GetString("stream")
+			eventPath, _ := cmd.Flags().GetString("event")
+			return filterTest(hookNumber, streamName, eventPath)
+		},
+	}
+	cmdFilter.AddCommand(cmdFilterTest)
+
+	cmdFilterTest.Flags().IntP("webhook", "w", 0, "webhook to test, numbered from 1 as in config validate (default all)")
+	cmdFilterTest.Flags().StringP("event", "e", "-", "file of sample <notification>s, or - for stdin")
+	cmdFilterTest.Flags().String("stream", "", "stream the events came from (default each webhook's stream)")
+
+	cmdMockServer := &cobra.Command{
+		Use:   "mock-server",
+		Short: "run a fake NSO that serves event streams from files, for tests and demos",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return processConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			listen, _ := cmd.Flags().GetString("listen")
+			events, _ := cmd.Flags().GetStringSlice("events")
+			interval, _ := cmd.Flags().GetDuration("interval")
+			useTLS, _ := cmd.Flags().GetBool("tls")
+			return runMockServer(listen, events, interval, useTLS)
+		},
+	}
+
+	cmdMockServer.Flags().String("listen", defaultMockListen, "address to listen on")
+	cmdMockServer.Flags().StringSlice("events", nil, "event files (.xml, one stream per file), recordings (.jsonl) or directories (default built-in samples)")
+	cmdMockServer.Flags().Duration("interval", defaultMockRate, "time between events on each stream")
+	cmdMockServer.Flags().Bool("tls", false, "serve HTTPS with a self-signed certificate")
 
 	// Put all the commands together
 
 	baseCmd.AddCommand(cmdList)
 	baseCmd.AddCommand(cmdInfo)
 	baseCmd.AddCommand(cmdSubscribe)
+	baseCmd.AddCommand(cmdReplay)
+	baseCmd.AddCommand(cmdConfig)
+	baseCmd.AddCommand(cmdFilter)
+	baseCmd.AddCommand(cmdMockServer)
 
 	return baseCmd
 }
 
+// Bind a flag to a config key, remembering the binding so 'config show' can tell when a
+// value came from the command line
+
+var flagBindings = map[string]*pflag.Flag{}
+
+func bindFlag(key string, flag *pflag.Flag) {
+	flagBindings[strings.ToLower(key)] = flag
+	_ = viper.BindPFlag(key, flag)
+}
+
 // Required initialization for all commands. Basically bring up the connection to
-// the server and grab some basic info
-
-func mainStartup() (*NsoServer, error) {
-	// pprof profiling
-	if Config.profilingPort != 0 {
-		go func() {
-			http.ListenAndServe(fmt.Sprintf(":%d", Config.profilingPort), nil)
-		}()
-	}
+// the server(s) and grab some basic info
 
-	// New NSO server
-	server := newNSOServer()
+func mainStartup() (nsoServers, error) {
+	// pprof profiling and metrics
+	startHTTPServers()
 
-	// Determine the root resource as presented by the server
-	if err := server.getRootResource(); err != nil {
-		return nil, err
+	// New NSO server(s), or just those picked with --server
+	var servers nsoServers
+	for _, target := range Config().nsoTargets {
+		servers = append(servers, newNSOServer(target))
 	}
-
-	// Retrieve info about the current server state
-	if err := server.getState(); err != nil {
-		return nil, err
+	if names := viper.GetStringSlice("server"); len(names) > 0 {
+		var selected nsoServers
+		for _, name := range names {
+			s := servers.find(name)
+			if s == nil {
+				return nil, fmt.Errorf("unknown NSO server '%s'", name)
+			}
+			selected = append(selected, s)
+		}
+		servers = selected
 	}
 
-	// Retrieve the list of available streams from the server
-	if err := server.getStreamList(); err != nil {
-		return nil, err
+	for _, server := range servers {
+		// Find the primary node of a cluster
+		if server.clustered() {
+			if err := server.selectPrimary(); err != nil {
+				return nil, err
+			}
+		}
+
+		// Determine the root resource as presented by the server
+		if err := server.getRootResource(); err != nil {
+			return nil, err
+		}
+
+		// Retrieve info about the current server state
+		if err := server.getState(); err != nil {
+			return nil, err
+		}
+
+		// Retrieve the list of available streams from the server
+		if err := server.getStreamList(); err != nil {
+			return nil, err
+		}
 	}
-	return server, nil
+	return servers, nil
 }
@@ -0,0 +1,226 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A single NSO commit can produce several near-identical events. A webhook with a debounce
+// and/or dedupe setting holds on to events that share a key (event name, device set and any
+// configured payload fields) and delivers them as one request:
+//
+//   debounce:  deliver once no new matching event has arrived for the given duration, or
+//              maxWait (default 10 × debounce) after the first, so steady traffic still flows
+//   dedupe:    deliver when the window, measured from the first matching event, closes
+//
+// With both set, whichever deadline comes first wins
+
+const defaultMaxWaitFactor = 10
+
+type Dedupe struct {
+	Window time.Duration
+	Fields []string
+}
+
+// Payload fields that can contribute to the coalescing key
+
+var dedupeFields = map[string]bool{
+	"source":    true,
+	"stream":    true,
+	"eventname": true,
+	"user":      true,
+	"host":      true,
+	"datastore": true,
+	"devices":   true,
+	"edits":     true,
+}
+
+func (d *Dedupe) validate() error {
+	for _, f := range d.Fields {
+		if !dedupeFields[f] {
+			return fmt.Errorf("unknown dedupe field '%s'", f)
+		}
+	}
+	return nil
+}
+
+type pendingDelivery struct {
+	sub       streamSubscriber
+	bodies    [][]byte
+	marks     eventMarks
+	windowEnd time.Time
+	deadline  time.Time
+	timer     *time.Timer
+}
+
+type coalescer struct {
+	hook    *webhook
+	mu      sync.Mutex
+	pending map[string]*pendingDelivery
+	closed  bool // replaced by a config reload
+}
+
+func newCoalescer(hook *webhook) *coalescer {
+	return &coalescer{hook: hook, pending: make(map[string]*pendingDelivery)}
+}
+
+// Queue an event for delivery, merging it with any pending events for the same key
+
+func (c *coalescer) add(sub streamSubscriber, n *Notification, body []byte) {
+	key := c.key(n, body)
+	now := time.Now()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		c.hook.fire(sub, body)
+		return
+	}
+	defer c.mu.Unlock()
+
+	p, found := c.pending[key]
+	if !found {
+		inflight.start()
+		p = &pendingDelivery{sub: sub}
+		if c.hook.Dedupe != nil && c.hook.Dedupe.Window > 0 {
+			p.windowEnd = now.Add(c.hook.Dedupe.Window)
+		}
+		if c.hook.MaxWait > 0 {
+			if maxEnd := now.Add(c.hook.MaxWait); p.windowEnd.IsZero() || maxEnd.Before(p.windowEnd) {
+				p.windowEnd = maxEnd
+			}
+		}
+		c.pending[key] = p
+	}
+	p.bodies = append(p.bodies, body)
+	sub.marks.hold()
+	p.marks = append(p.marks, sub.marks...)
+
+	// Each new event pushes the debounce deadline out, but never past the dedupe window or
+	// the max wait
+
+	p.deadline = p.windowEnd
+	if c.hook.Debounce > 0 {
+		if quiet := now.Add(c.hook.Debounce); p.deadline.IsZero() || quiet.Before(p.deadline) {
+			p.deadline = quiet
+		}
+	}
+
+	logger.Debug("(coalescer:add) event held", "stream", sub.stream.label(), "webhook", c.hook.target(),
+		"key", key, "pending", len(p.bodies))
+
+	if p.timer == nil {
+		p.timer = time.AfterFunc(p.deadline.Sub(now), func() { c.flush(key, p) })
+	}
+}
+
+// Timer callback. The deadline may have moved since the timer was armed, in which case
+// it's simply re-armed
+
+func (c *coalescer) flush(key string, p *pendingDelivery) {
+	c.mu.Lock()
+	if c.pending[key] != p {
+		c.mu.Unlock()
+		return
+	}
+	if wait := time.Until(p.deadline); wait > 0 {
+		p.timer.Reset(wait)
+		c.mu.Unlock()
+		return
+	}
+	delete(c.pending, key)
+	c.mu.Unlock()
+	defer inflight.finish()
+	defer p.marks.release()
+
+	body, err := mergePayloads(p.bodies)
+	if err != nil {
+		logger.Error("(coalescer:flush) merging payloads", "stream", p.sub.stream.label(), "webhook", c.hook.target(), "err", err)
+		return
+	}
+	if count := len(p.bodies); count > 1 {
+		logger.Info(fmt.Sprintf("(coalescer:flush) %d events merged", count), "stream", p.sub.stream.label(), "webhook", c.hook.target())
+	}
+	sub := p.sub
+	sub.marks = p.marks
+	c.hook.fire(sub, body)
+}
+
+// Deliver everything pending right away, used at shutdown
+
+func (c *coalescer) flushAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, p := range c.pending {
+		p.deadline = time.Now()
+		if p.timer.Stop() {
+			go c.flush(key, p)
+		}
+	}
+}
+
+// Stop holding events once a reload has replaced the webhook: what's pending goes now, and
+// events still arriving for the old webhook are delivered as they come
+
+func (c *coalescer) close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.flushAll()
+}
+
+// Build the coalescing key from the event name, the device set and any configured fields
+
+func (c *coalescer) key(n *Notification, body []byte) string {
+	devices := append([]string(nil), n.Devices...)
+	sort.Strings(devices)
+	key := n.Server + "|" + n.EventName + "|" + strings.Join(devices, ",")
+
+	if c.hook.Dedupe == nil || len(c.hook.Dedupe.Fields) == 0 {
+		return key
+	}
+
+	var fields map[string]json.RawMessage
+	_ = json.Unmarshal(body, &fields)
+	for _, f := range c.hook.Dedupe.Fields {
+		key = key + "|" + string(fields[f])
+	}
+	return key
+}
+
+// A single event is delivered untouched. Several are delivered as the most recent event's
+// payload plus a count and the list of all the merged payloads, oldest first
+
+func mergePayloads(bodies [][]byte) ([]byte, error) {
+	if len(bodies) == 1 {
+		return bodies[0], nil
+	}
+
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(bodies[len(bodies)-1], &merged); err != nil {
+		return nil, err
+	}
+
+	events := make([]json.RawMessage, len(bodies))
+	for i, b := range bodies {
+		events[i] = b
+	}
+	merged["count"] = json.RawMessage(strconv.Itoa(len(bodies)))
+	eventList, err := jsonMarshal(events)
+	if err != nil {
+		return nil, err
+	}
+	merged["events"] = eventList
+
+	return jsonMarshal(merged)
+}
@@ -0,0 +1,176 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func coalesceTestHook(debounce, maxWait time.Duration, dedupe *Dedupe) (*webhook, *scriptedSink) {
+	hook, sink := guardTestHook()
+	hook.Debounce, hook.MaxWait, hook.Dedupe = debounce, maxWait, dedupe
+	hook.coalescer = newCoalescer(hook)
+	return hook, sink
+}
+
+func coalesceTestEvent(server, name, user string, devices ...string) (*Notification, []byte) {
+	n := &Notification{Server: server, EventName: name, User: user, Devices: devices}
+	body, _ := json.Marshal(map[string]interface{}{"server": server, "eventname": name, "user": user, "devices": devices})
+	return n, body
+}
+
+// Delivered payloads as their count and the users of the merged events
+
+func coalescedDeliveries(t *testing.T, sink *scriptedSink) []string {
+	if inflight.wait(5*time.Second) != 0 {
+		t.Fatal("deliveries didn't finish")
+	}
+	var got []string
+	for _, body := range sink.delivered() {
+		var payload struct {
+			User   string
+			Count  int
+			Events []struct{ User string }
+		}
+		if err := json.Unmarshal([]byte(body), &payload); err != nil {
+			t.Fatal(err)
+		}
+		users := payload.User
+		if payload.Count > 0 {
+			users = ""
+			for _, e := range payload.Events {
+				users += e.User
+			}
+		}
+		got = append(got, fmt.Sprintf("%d:%s", payload.Count, users))
+	}
+	return got
+}
+
+func TestCoalescerKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		same  bool
+		first []string // server, eventname, user, devices...
+		other []string
+	}{
+		{"identical", true, []string{"nso1", "commit", "a", "R0", "R1"}, []string{"nso1", "commit", "a", "R0", "R1"}},
+		{"device order", true, []string{"nso1", "commit", "a", "R0", "R1"}, []string{"nso1", "commit", "a", "R1", "R0"}},
+		{"field not in the key", true, []string{"nso1", "commit", "a", "R0"}, []string{"nso1", "commit", "b", "R0"}},
+		{"server", false, []string{"nso1", "commit", "a", "R0"}, []string{"nso2", "commit", "a", "R0"}},
+		{"event", false, []string{"nso1", "commit", "a", "R0"}, []string{"nso1", "rollback", "a", "R0"}},
+		{"devices", false, []string{"nso1", "commit", "a", "R0"}, []string{"nso1", "commit", "a", "R0", "R1"}},
+	}
+	plain := newCoalescer(&webhook{})
+	for _, test := range tests {
+		n1, b1 := coalesceTestEvent(test.first[0], test.first[1], test.first[2], test.first[3:]...)
+		n2, b2 := coalesceTestEvent(test.other[0], test.other[1], test.other[2], test.other[3:]...)
+		if same := plain.key(n1, b1) == plain.key(n2, b2); same != test.same {
+			t.Errorf("%s: same key %v, want %v", test.name, same, test.same)
+		}
+	}
+
+	// A dedupe field separates events that differ in it
+
+	byUser := newCoalescer(&webhook{Dedupe: &Dedupe{Fields: []string{"user"}}})
+	n1, b1 := coalesceTestEvent("nso1", "commit", "a", "R0")
+	n2, b2 := coalesceTestEvent("nso1", "commit", "b", "R0")
+	if byUser.key(n1, b1) == byUser.key(n2, b2) {
+		t.Error("events for different users share a key with dedupe fields [user]")
+	}
+}
+
+// Events sharing a key are delivered as one, oldest first; others separately
+
+func TestCoalescerMerge(t *testing.T) {
+	sub := streamSubscriber{stream: &Stream{Name: "NETCONF", server: "nso1"}}
+	hook, sink := coalesceTestHook(time.Hour, 0, nil)
+
+	events := [][]string{
+		{"nso1", "commit", "a", "R0"},
+		{"nso1", "commit", "b", "R0"},
+		{"nso1", "commit", "c", "R0", "R1"},
+		{"nso1", "commit", "d", "R0"},
+		{"nso2", "commit", "e", "R0"},
+	}
+	for _, e := range events {
+		n, body := coalesceTestEvent(e[0], e[1], e[2], e[3:]...)
+		hook.coalescer.add(sub, n, body)
+	}
+	if pending := len(hook.coalescer.pending); pending != 3 {
+		t.Fatalf("%d deliveries pending, want 3", pending)
+	}
+	if sent := sink.delivered(); len(sent) != 0 {
+		t.Fatalf("delivered %q before the debounce", sent)
+	}
+
+	hook.coalescer.flushAll()
+	got := coalescedDeliveries(t, sink)
+	want := map[string]bool{"3:abd": true, "0:c": true, "0:e": true}
+	if len(got) != len(want) {
+		t.Fatalf("deliveries %q, want %d", got, len(want))
+	}
+	for _, d := range got {
+		if !want[d] {
+			t.Errorf("delivery %s not expected, want count:users among %v", d, want)
+		}
+	}
+}
+
+// Steady traffic pushes the debounce out, but not past maxWait from the first event
+
+func TestCoalescerMaxWait(t *testing.T) {
+	sub := streamSubscriber{stream: &Stream{Name: "NETCONF", server: "nso1"}}
+	hook, sink := coalesceTestHook(time.Hour, 50*time.Millisecond, nil)
+	n, body := coalesceTestEvent("nso1", "commit", "a", "R0")
+
+	hook.coalescer.add(sub, n, body)
+	first := time.Now()
+	hook.coalescer.add(sub, n, body)
+	hook.coalescer.mu.Lock()
+	for _, p := range hook.coalescer.pending {
+		if p.deadline.After(first.Add(50 * time.Millisecond)) {
+			t.Errorf("deadline %v after the first event, want at most maxWait", p.deadline.Sub(first))
+		}
+	}
+	hook.coalescer.mu.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.delivered()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := coalescedDeliveries(t, sink); !reflect.DeepEqual(got, []string{"2:aa"}) {
+		t.Errorf("deliveries %q, want the two events merged at maxWait", got)
+	}
+}
+
+// Closing delivers what's pending, and later events go straight through
+
+func TestCoalescerClose(t *testing.T) {
+	sub := streamSubscriber{stream: &Stream{Name: "NETCONF", server: "nso1"}}
+	hook, sink := coalesceTestHook(time.Hour, 0, nil)
+	n, body := coalesceTestEvent("nso1", "commit", "a", "R0")
+
+	hook.coalescer.add(sub, n, body)
+	hook.coalescer.add(sub, n, body)
+	hook.coalescer.close()
+	if got := coalescedDeliveries(t, sink); !reflect.DeepEqual(got, []string{"2:aa"}) {
+		t.Fatalf("deliveries %q at close, want the pending events merged", got)
+	}
+
+	n, body = coalesceTestEvent("nso1", "commit", "b", "R0")
+	hook.coalescer.add(sub, n, body)
+	if got := coalescedDeliveries(t, sink); !reflect.DeepEqual(got, []string{"2:aa", "0:b"}) {
+		t.Errorf("deliveries %q, want the event after close delivered as it came", got)
+	}
+	if pending := len(hook.coalescer.pending); pending != 0 {
+		t.Errorf("%d deliveries pending after close", pending)
+	}
+}
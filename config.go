@@ -9,9 +9,10 @@ import (
 	"fmt"
 	"github.com/mattn/go-isatty"
 	"github.com/spf13/viper"
+	"log/slog"
+	"net/url"
 	"os"
-	"regexp"
-	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,30 +28,48 @@ const (
 	defaultWebhookUser  = "netgitops"
 )
 
-var restconfApiRE = regexp.MustCompile("http(s?)://([0-9A-Za-z.]*):?([0-9]*)?")
+type configuration struct {
+	logLevel           slog.Level
+	logFormat          string
+	noColor            bool
+	profilingPort      int
+	metricsPort        int
+	healthPort         int
+	showMounts         bool
+	nsoTargets         []*nsoInfo
+	connectTimeout     time.Duration
+	readTimeout        time.Duration
+	keepaliveThreshold time.Duration
+	streamNames        []string
+	ordering           OrderingMode
+	shutdownGrace      time.Duration
+	checkpointFile     string
+	recordFile         string
+	dryRun             bool
+	watchConfig        bool
+	webhooks           webhooks
+}
+
+// The config in effect. A reload builds a new one and swaps it in whole, so code running
+// alongside a reload takes one snapshot (once per event, say) and works from that
 
-type nsoInfo struct {
-	cmdUrl    string
-	apiUrl    string
-	ipAddress string
-	port      int
-	user      string
-	password  string
+var liveConfig atomic.Pointer[configuration]
+
+func init() {
+	liveConfig.Store(new(configuration))
 }
 
-var Config struct {
-	verbose        bool
-	debug          bool
-	noColor        bool
-	profilingPort  int
-	showMounts     bool
-	nsoTarget      nsoInfo
-	connectTimeout time.Duration
-	readTimeout    time.Duration
-	streamNames    []string
-	webhooks       webhooks
+func Config() *configuration {
+	return liveConfig.Load()
 }
 
+// Set by the --config flag
+
+var (
+	configFile      string
+	configLoadError error
+)
+
 func initConfig() {
 
 	// Look for any environment variables prefixed with "NSOEVENT_"
@@ -64,101 +83,239 @@ func initConfig() {
 	viper.SetDefault("nso.password", defaultNSOPassword)
 	viper.SetDefault("nso.restconfAPI", fmt.Sprintf("http://%s:%d", defaultNSOAddress, defaultNSOPort))
 
-	viper.SetConfigName(defaultConfigFile)
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath("$HOME/." + programName)
-	viper.AddConfigPath(".")
+	// An explicit config file (--config or NSOEVENT_CONFIG) must exist. Otherwise look for
+	// the default name in the usual places
 
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			panic(fmt.Errorf("(initConfig) fatal error accessing config file: %v", err))
-		}
+	if configFile == "" {
+		configFile = os.Getenv("NSOEVENT_CONFIG")
+	}
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+	} else {
+		viper.SetConfigName(defaultConfigFile)
+		viper.SetConfigType("yaml")
+		viper.AddConfigPath("$HOME/." + programName)
+		viper.AddConfigPath(".")
+	}
+
+	// Don't give up here: commands report the error from processConfig, and 'config
+	// validate' can explain it
+
+	if err := loadConfigFiles(); err != nil {
+		configLoadError = fmt.Errorf("(initConfig) fatal error accessing config file: %v", err)
 	}
 }
 
 func processConfig() error {
-	// Global settings & flags
-	Config.verbose = viper.GetBool("verbose")
-	Config.debug = viper.GetBool("debug")
-	Config.noColor = viper.GetBool("nocolor")
-	Config.profilingPort = viper.GetInt("pprofPort")
-	Config.nsoTarget.cmdUrl = viper.GetString("nso.restconfAPI")
-	Config.nsoTarget.user = viper.GetString("nso.user")
-	Config.nsoTarget.password = viper.GetString("nso.password")
-	Config.connectTimeout = viper.GetDuration("nso.connectTimeout")
-	Config.readTimeout = viper.GetDuration("nso.readTimeout")
+	c, err := readConfig(false)
+	if err != nil {
+		return err
+	}
+	liveConfig.Store(c)
+	return nil
+}
 
-	// info models command
-	Config.showMounts = viper.GetBool("mounts")
+// Build a config from the files, flags and environment. Logging is only set up the first
+// time: loggers are in use everywhere once subscribers are running, so a reload keeps the
+// current log settings
 
-	// Subscribe command
-	Config.streamNames = viper.GetStringSlice("stream")
+func readConfig(reload bool) (*configuration, error) {
+	if configLoadError != nil {
+		return nil, configLoadError
+	}
+	c := new(configuration)
+
+	// Global settings & flags
+	c.noColor = viper.GetBool("nocolor")
 
 	// Override the color setting if trying to do color with something that can't
 	if os.Getenv("TERM") == "dumb" || (!isatty.IsTerminal(os.Stdout.Fd()) && !isatty.IsCygwinTerminal(os.Stdout.Fd())) {
-		Config.noColor = true
+		c.noColor = true
 	}
 
-	// Parse the NSO URL
-	if Config.nsoTarget.cmdUrl == "" {
-		return fmt.Errorf("(processConfig) NSO API URL not found")
-	}
+	c.profilingPort = viper.GetInt("pprofPort")
+	c.metricsPort = viper.GetInt("metricsPort")
+	c.healthPort = viper.GetInt("healthPort")
+	c.keepaliveThreshold = viper.GetDuration("keepaliveThreshold")
+	c.connectTimeout = viper.GetDuration("nso.connectTimeout")
+	c.readTimeout = viper.GetDuration("nso.readTimeout")
 
-	subMatches := restconfApiRE.FindStringSubmatch(Config.nsoTarget.cmdUrl)
-	if len(subMatches) < 3 {
-		return fmt.Errorf("(processConfig) cannot parse NSO API URL '%s'", Config.nsoTarget.cmdUrl)
-	}
+	// info models command
+	c.showMounts = viper.GetBool("mounts")
 
-	// TLS?
-	protocol := "http"
-	if subMatches[1] != "" {
-		protocol = "https"
-		fmt.Println(stringColorize("WARNING: TLS protocol detected, but insecure verify is true!", COLOR_WARNING))
+	// Subscribe command
+	c.streamNames = viper.GetStringSlice("stream")
+	ordering, err := parseOrderingMode(viper.GetString("ordering"))
+	if err != nil {
+		return nil, fmt.Errorf("(processConfig) %v", err)
 	}
+	c.ordering = ordering
+	c.shutdownGrace = viper.GetDuration("shutdownGrace")
+	c.checkpointFile = viper.GetString("checkpointFile")
+	c.watchConfig = viper.GetBool("watchConfig")
+	c.recordFile = viper.GetString("recordFile")
+	c.dryRun = viper.GetBool("dryRun")
 
-	Config.nsoTarget.ipAddress = defaultNSOAddress
-	if subMatches[2] != "" {
-		Config.nsoTarget.ipAddress = subMatches[2]
-	}
+	// Logging. The --debug and --verbose shorthands can only lower the level
 
-	Config.nsoTarget.port = defaultNSOPort
-	if len(subMatches) == 4 && subMatches[3] != "" {
-		port, err := strconv.Atoi(subMatches[3])
-		if err != nil {
-			return fmt.Errorf("(processConfig) invalid NSO API port in '%s'", subMatches[0])
+	logLevel, err := parseLogLevel(viper.GetString("log.level"))
+	if err != nil {
+		return nil, fmt.Errorf("(processConfig) %v", err)
+	}
+	if viper.GetBool("debug") && logLevel > slog.LevelDebug {
+		logLevel = slog.LevelDebug
+	}
+	if viper.GetBool("verbose") {
+		logLevel = levelTrace
+	}
+	c.logLevel = logLevel
+	c.logFormat = viper.GetString("log.format")
+	if c.logFormat == logFormatJSON {
+		c.noColor = true
+	}
+	if reload {
+		current := Config()
+		if c.logLevel != current.logLevel || c.logFormat != current.logFormat {
+			logger.Warn("log settings changed, restart to apply them")
+		}
+		c.logLevel, c.logFormat, c.noColor = current.logLevel, current.logFormat, current.noColor
+	} else {
+		if err := initLogging(c.logFormat, c.logLevel); err != nil {
+			return nil, fmt.Errorf("(processConfig) %v", err)
 		}
-		Config.nsoTarget.port = port
+		logger.Debug("debug output enabled")
 	}
 
-	Config.nsoTarget.apiUrl = fmt.Sprintf("%s://%s:%d", protocol, Config.nsoTarget.ipAddress, Config.nsoTarget.port)
-
-	if Config.debug {
-		enableDebug()
+	// The NSO server(s). Credentials may be secret references, resolved now that logging
+	// is set up
+	if err := c.processNSOTargets(); err != nil {
+		return nil, fmt.Errorf("(processConfig) %v", err)
 	}
 
 	// Webhook definitions
 
-	if err := viper.UnmarshalKey("webhooks", &Config.webhooks); err != nil {
-		return fmt.Errorf("(processConfig) fatal error processing config file for 'webhooks' key: %v", err)
+	if err := viper.UnmarshalKey("webhooks", &c.webhooks); err != nil {
+		return nil, fmt.Errorf("(processConfig) fatal error processing config file for 'webhooks' key: %v", err)
 	}
 
 	// Initial validation of webhook definitions
-	if hookCount := len(Config.webhooks); hookCount > 0 {
-		for i, hook := range Config.webhooks {
+	if hookCount := len(c.webhooks); hookCount > 0 {
+		files := map[string]*FileSink{} // webhooks writing to a file share it, and its rotation
+		for i, hook := range c.webhooks {
 			if hook.Stream == "" {
-				return fmt.Errorf("(processConfig) fatal error in config file: webhook %d - missing stream name", i+1)
+				return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - missing stream name", webhookRef(i))
 			}
-			if hook.Url == "" {
-				return fmt.Errorf("(processConfig) fatal error in config file: webhook %d - missing target URL", i+1)
+			if hook.sinkType, err = parseSinkType(hook.Type); err != nil {
+				return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - %v", webhookRef(i), err)
+			}
+			switch hook.sinkType {
+			case SINK_HTTP:
+				if hook.Url == "" {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - missing target URL", webhookRef(i))
+				}
+			case SINK_FILE:
+				if hook.File == nil || hook.File.Path == "" {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - missing file path", webhookRef(i))
+				}
+				if hook.File.MaxSize <= 0 {
+					hook.File.MaxSize = defaultFileMaxSize
+				}
+				if hook.File.Keep <= 0 {
+					hook.File.Keep = defaultFileKeep
+				}
+				if other, found := files[hook.File.Path]; found && (other.MaxSize != hook.File.MaxSize || other.Keep != hook.File.Keep) {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - file %s is written by another webhook with a different maxSize or keep", webhookRef(i), hook.File.Path)
+				}
+				files[hook.File.Path] = hook.File
+			case SINK_EXEC:
+				if hook.Exec == nil || hook.Exec.Command == "" {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - missing exec command", webhookRef(i))
+				}
+			case SINK_SYSLOG:
+				if hook.Syslog == nil {
+					hook.Syslog = &SyslogSink{}
+				}
+				if err := hook.Syslog.validate(); err != nil {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - %v", webhookRef(i), err)
+				}
+			case SINK_KAFKA, SINK_NATS, SINK_MQTT:
+				if hook.Publish == nil {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - missing publish settings", webhookRef(i))
+				}
+				if err := hook.Publish.validate(hook.sinkType); err != nil {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - %v", webhookRef(i), err)
+				}
+				if hook.Publish.Password, err = resolveSecret(hook.Publish.Password); err != nil {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - publish password: %v", webhookRef(i), err)
+				}
+			}
+			if hook.Token, err = resolveSecret(hook.Token); err != nil {
+				return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - token: %v", webhookRef(i), err)
+			}
+			if hook.ApiToken, err = resolveSecret(hook.ApiToken); err != nil {
+				return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - apiToken: %v", webhookRef(i), err)
 			}
 			if hook.User != "" && hook.ApiToken == "" {
-				return fmt.Errorf("(processConfig) fatal error in config file: webhook %d - missing API token for user %s@%s", i+1, hook.User, hook.Url)
+				return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - missing API token for user %s@%s", webhookRef(i), hook.User, hook.Url)
 			}
 			if hook.User == "" && hook.ApiToken == "" {
-				Config.webhooks[i].User = defaultWebhookUser
+				c.webhooks[i].User = defaultWebhookUser
+			}
+			if hook.Debounce < 0 {
+				return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - negative debounce %v", webhookRef(i), hook.Debounce)
+			}
+			if hook.MaxWait < 0 {
+				return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - negative maxWait %v", webhookRef(i), hook.MaxWait)
+			}
+			if hook.MaxWait == 0 {
+				hook.MaxWait = defaultMaxWaitFactor * hook.Debounce
+			}
+			if hook.Dedupe != nil {
+				if hook.Dedupe.Window <= 0 && hook.Debounce == 0 {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - dedupe requires a window or a debounce", webhookRef(i))
+				}
+				if err := hook.Dedupe.validate(); err != nil {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - %v", webhookRef(i), err)
+				}
+			}
+			if hook.RateLimit != nil {
+				if err := hook.RateLimit.validate(); err != nil {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - %v", webhookRef(i), err)
+				}
+			}
+			for _, name := range hook.Servers {
+				if c.findNSOTarget(name) == nil {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - unknown NSO server '%s'", webhookRef(i), name)
+				}
+			}
+			if hook.ConnectTimeout < 0 || hook.Timeout < 0 {
+				return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - negative timeout", webhookRef(i))
+			}
+			if hook.Proxy != "" && hook.Proxy != "none" {
+				if proxyUrl, err := url.Parse(hook.Proxy); err != nil || proxyUrl.Host == "" {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - invalid proxy '%s' (expected a URL or 'none')", webhookRef(i), hook.Proxy)
+				}
+			}
+			if hook.CircuitBreaker != nil {
+				if err := hook.CircuitBreaker.validate(); err != nil {
+					return nil, fmt.Errorf("(processConfig) fatal error in config file: %s - %v", webhookRef(i), err)
+				}
 			}
 		}
 	}
 
-	return nil
+	// Events written to stdout shouldn't be mixed up with the logs
+
+	if c.webhooks.useStdout() && logOutput != os.Stderr {
+		if reload {
+			logger.Warn("a webhook now writes events to stdout, restart to move the logs to stderr")
+			return c, nil
+		}
+		logOutput = os.Stderr
+		if err := initLogging(c.logFormat, c.logLevel); err != nil {
+			return nil, fmt.Errorf("(processConfig) %v", err)
+		}
+	}
+
+	return c, nil
 }
@@ -0,0 +1,393 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// Static checking of the config files for 'config validate'. Each file is walked against
+// a schema of the known keys so that problems can be reported with file and line numbers,
+// before the merged result is put through processConfig like any other command would
+
+type valueKind int
+
+const (
+	KIND_STRING valueKind = iota
+	KIND_BOOL
+	KIND_INT
+	KIND_FLOAT
+	KIND_DURATION
+	KIND_URL
+	KIND_REGEXP
+	KIND_STRING_LIST
+	KIND_STRING_MAP
+	KIND_MAP
+	KIND_LIST
+	KIND_MAP_OR_LIST
+)
+
+type schemaNode struct {
+	kind   valueKind
+	fields configSchema // KIND_MAP fields, or the fields of each list item
+	values []string     // Allowed values, if restricted
+}
+
+type configSchema map[string]*schemaNode
+
+// Keys are matched without regard to case, as viper does
+
+func (schema configSchema) lookup(key string) (string, *schemaNode) {
+	for name, node := range schema {
+		if strings.EqualFold(name, key) {
+			return name, node
+		}
+	}
+	return "", nil
+}
+
+var (
+	schemaString   = &schemaNode{kind: KIND_STRING}
+	schemaBool     = &schemaNode{kind: KIND_BOOL}
+	schemaInt      = &schemaNode{kind: KIND_INT}
+	schemaFloat    = &schemaNode{kind: KIND_FLOAT}
+	schemaDuration = &schemaNode{kind: KIND_DURATION}
+	schemaURL      = &schemaNode{kind: KIND_URL}
+	schemaRegexp   = &schemaNode{kind: KIND_REGEXP}
+	schemaList     = &schemaNode{kind: KIND_STRING_LIST}
+)
+
+func schemaEnum(values ...string) *schemaNode {
+	return &schemaNode{kind: KIND_STRING, values: values}
+}
+
+// A map with keys of the user's choosing, each with a single value
+
+func schemaStringMap(values ...string) *schemaNode {
+	return &schemaNode{kind: KIND_STRING_MAP, values: values}
+}
+
+func schemaMap(fields configSchema) *schemaNode {
+	return &schemaNode{kind: KIND_MAP, fields: fields}
+}
+
+func schemaListOf(fields configSchema) *schemaNode {
+	return &schemaNode{kind: KIND_LIST, fields: fields}
+}
+
+func schemaMapOrList(fields configSchema) *schemaNode {
+	return &schemaNode{kind: KIND_MAP_OR_LIST, fields: fields}
+}
+
+// Keep in step with processConfig, TLSConfig and the webhook structure
+
+var syslogSeveritySchema = schemaEnum(append(syslogSeverities[:],
+	"error", "warn", "critical", "emergency")...)
+
+var tlsSchema = schemaMap(configSchema{
+	"ca":         schemaString,
+	"cert":       schemaString,
+	"key":        schemaString,
+	"serverName": schemaString,
+	"insecure":   schemaBool,
+})
+
+var webhookSchema = configSchema{
+	"stream":   schemaString,
+	"servers":  schemaList,
+	"disable":  schemaBool,
+	"type":     schemaEnum("http", "https", "file", "stdout", "exec", "syslog", "kafka", "nats", "mqtt"),
+	"url":      schemaURL,
+	"user":     schemaString,
+	"apiToken": schemaString,
+	"token":    schemaString,
+	"filter": schemaMap(configSchema{
+		"event": schemaString,
+		"node": schemaListOf(configSchema{
+			"name":  schemaString,
+			"value": schemaRegexp,
+		}),
+	}),
+	"debounce": schemaDuration,
+	"maxWait":  schemaDuration,
+	"dedupe": schemaMap(configSchema{
+		"window": schemaDuration,
+		"fields": schemaList,
+	}),
+	"rateLimit": schemaMap(configSchema{
+		"rate":  schemaFloat,
+		"burst": schemaInt,
+	}),
+	"circuitBreaker": schemaMap(configSchema{
+		"failures":  schemaInt,
+		"cooldown":  schemaDuration,
+		"policy":    schemaEnum("queue", "drop"),
+		"queueSize": schemaInt,
+	}),
+	"tls":            tlsSchema,
+	"proxy":          schemaString,
+	"connectTimeout": schemaDuration,
+	"timeout":        schemaDuration,
+	"file": schemaMap(configSchema{
+		"path":    schemaString,
+		"maxSize": schemaInt,
+		"keep":    schemaInt,
+	}),
+	"exec": schemaMap(configSchema{
+		"command": schemaString,
+		"args":    schemaList,
+	}),
+	"syslog": schemaMap(configSchema{
+		"network":         schemaEnum("udp", "tcp", "tls", "unix", "journald"),
+		"address":         schemaString,
+		"facility":        schemaEnum(syslogFacilities[:]...),
+		"appName":         schemaString,
+		"defaultSeverity": syslogSeveritySchema,
+		"severity":        schemaStringMap(syslogSeveritySchema.values...),
+		"payload":         schemaBool,
+	}),
+	"publish": schemaMap(configSchema{
+		"servers":     schemaList,
+		"topic":       schemaString,
+		"user":        schemaString,
+		"password":    schemaString,
+		"acks":        schemaEnum("all", "one", "none"),
+		"qos":         schemaEnum("0", "1", "2"),
+		"retain":      schemaBool,
+		"retainTopic": schemaString,
+		"version":     schemaEnum("3.1.1", "5"),
+		"clientId":    schemaString,
+	}),
+}
+
+var rootSchema = configSchema{
+	"nso": schemaMapOrList(configSchema{
+		"name":            schemaString,
+		"restconfAPI":     schemaURL,
+		"nodes":           schemaList,
+		"haCheckInterval": schemaDuration,
+		"user":            schemaString,
+		"password":        schemaString,
+		"auth":            schemaEnum("basic", "bearer", "token", "session", "cookie"),
+		"token":           schemaString,
+		"connectTimeout":  schemaDuration,
+		"readTimeout":     schemaDuration,
+		"tls":             tlsSchema,
+	}),
+	"webhooks":           schemaListOf(webhookSchema),
+	"include":            schemaList,
+	"configDir":          schemaString,
+	"stream":             schemaList,
+	"server":             schemaList,
+	"ordering":           schemaEnum("none", "device", "stream"),
+	"shutdownGrace":      schemaDuration,
+	"checkpointFile":     schemaString,
+	"recordFile":         schemaString,
+	"dryRun":             schemaBool,
+	"watchConfig":        schemaBool,
+	"keepaliveThreshold": schemaDuration,
+	"pprofPort":          schemaInt,
+	"metricsPort":        schemaInt,
+	"healthPort":         schemaInt,
+	"log": schemaMap(configSchema{
+		"level":  schemaEnum("trace", "debug", "info", "warn", "warning", "error"),
+		"format": schemaEnum(logFormatConsole, logFormatJSON),
+	}),
+	"verbose": schemaBool,
+	"debug":   schemaBool,
+	"nocolor": schemaBool,
+	"mounts":  schemaBool,
+}
+
+type configProblem struct {
+	file string
+	line int
+	msg  string
+}
+
+func (p configProblem) String() string {
+	if p.line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.file, p.line, p.msg)
+	}
+	return fmt.Sprintf("%s: %s", p.file, p.msg)
+}
+
+// Check every config file that was read, then the merged config as a whole
+
+func validateConfig() []configProblem {
+	var problems []configProblem
+
+	if configLoadError != nil {
+		problems = append(problems, configProblem{file: configFileName(), msg: configLoadError.Error()})
+	}
+
+	for _, src := range configSources {
+		problems = append(problems, checkConfigFile(src.file)...)
+	}
+
+	if configLoadError == nil {
+		if err := processConfig(); err != nil {
+			problems = append(problems, configProblem{file: configFileName(), msg: err.Error()})
+			return problems
+		}
+
+		// The same webhook checks subscribe makes, short of connecting to anything
+
+		for i, hook := range Config().webhooks {
+			for _, err := range hook.check() {
+				problems = append(problems, configProblem{file: configFileName(), msg: fmt.Sprintf("%s %s - %v", webhookRef(i), hook.target(), err)})
+			}
+		}
+	}
+	return problems
+}
+
+func configFileName() string {
+	if f := viper.ConfigFileUsed(); f != "" {
+		return f
+	}
+	return "(no config file)"
+}
+
+func checkConfigFile(file string) []configProblem {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return []configProblem{{file: file, msg: err.Error()}}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return []configProblem{{file: file, msg: err.Error()}}
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	var problems []configProblem
+	report := func(n *yaml.Node, format string, v ...interface{}) {
+		problems = append(problems, configProblem{file: file, line: n.Line, msg: fmt.Sprintf(format, v...)})
+	}
+	checkMapping(doc.Content[0], rootSchema, "", report)
+	return problems
+}
+
+func checkMapping(n *yaml.Node, schema configSchema, path string, report func(*yaml.Node, string, ...interface{})) {
+	if n.Kind != yaml.MappingNode {
+		report(n, "'%s' should be a map", strings.TrimSuffix(path, "."))
+		return
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		_, field := schema.lookup(key.Value)
+		if field == nil {
+			report(key, "unknown key '%s%s'%s", path, key.Value, suggestKey(key.Value, schema))
+			continue
+		}
+		checkValue(value, field, path+key.Value, report)
+	}
+}
+
+func checkValue(n *yaml.Node, field *schemaNode, path string, report func(*yaml.Node, string, ...interface{})) {
+	switch field.kind {
+	case KIND_MAP:
+		checkMapping(n, field.fields, path+".", report)
+		return
+	case KIND_STRING_MAP:
+		if n.Kind != yaml.MappingNode {
+			report(n, "'%s' should be a map", path)
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			checkValue(n.Content[i+1], &schemaNode{kind: KIND_STRING, values: field.values}, path+"."+n.Content[i].Value, report)
+		}
+		return
+	case KIND_MAP_OR_LIST:
+		if n.Kind != yaml.SequenceNode {
+			checkMapping(n, field.fields, path+".", report)
+			return
+		}
+		for i, item := range n.Content {
+			checkMapping(item, field.fields, fmt.Sprintf("%s[%d].", path, i), report)
+		}
+		return
+	case KIND_LIST:
+		if n.Kind != yaml.SequenceNode {
+			report(n, "'%s' should be a list", path)
+			return
+		}
+		for i, item := range n.Content {
+			checkMapping(item, field.fields, fmt.Sprintf("%s[%d].", path, i), report)
+		}
+		return
+	case KIND_STRING_LIST:
+		if n.Kind == yaml.SequenceNode {
+			for _, item := range n.Content {
+				if item.Kind != yaml.ScalarNode {
+					report(item, "'%s' should be a list of strings", path)
+				}
+			}
+			return
+		}
+	}
+
+	if n.Kind != yaml.ScalarNode {
+		report(n, "'%s' should be a single value", path)
+		return
+	}
+
+	var err error
+	switch field.kind {
+	case KIND_BOOL:
+		_, err = strconv.ParseBool(n.Value)
+	case KIND_INT:
+		_, err = strconv.Atoi(n.Value)
+	case KIND_FLOAT:
+		_, err = strconv.ParseFloat(n.Value, 64)
+	case KIND_DURATION:
+		if _, intErr := strconv.Atoi(n.Value); intErr != nil {
+			_, err = time.ParseDuration(n.Value)
+		}
+	case KIND_URL:
+		var u *url.URL
+		if u, err = url.Parse(n.Value); err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+			err = fmt.Errorf("expected http(s)://host[:port]")
+		}
+	case KIND_REGEXP:
+		_, err = regexp.Compile(n.Value)
+	}
+	if err != nil {
+		report(n, "'%s': invalid value '%s': %v", path, n.Value, err)
+		return
+	}
+
+	if len(field.values) > 0 {
+		for _, v := range field.values {
+			if strings.EqualFold(v, n.Value) {
+				return
+			}
+		}
+		report(n, "'%s': invalid value '%s' (expected one of %s)", path, n.Value, strings.Join(field.values, ", "))
+	}
+}
+
+// A hint for likely typos, such as a known key written with dashes or underscores
+
+func suggestKey(key string, schema configSchema) string {
+	if name, _ := schema.lookup(strings.NewReplacer("-", "", "_", "").Replace(key)); name != "" {
+		return fmt.Sprintf(" (did you mean '%s'?)", name)
+	}
+	return ""
+}
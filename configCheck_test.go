@@ -0,0 +1,61 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// config validate reports webhooks that subscribe would reject, as well as problems in
+// the files themselves
+
+func TestValidateConfigWebhooks(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	config := `
+nso:
+  restconfAPI: http://127.0.0.1:8080
+webhooks:
+  - stream: NETCONF
+    url: http://127.0.0.1:9/hook
+  - stream: NETCONF
+    type: exec
+    exec:
+      command: /nonexistent/nsoevent-hook
+  - stream: NETCONF
+    type: file
+    file:
+      path: ` + filepath.Join(dir, "missing", "events.jsonl") + `
+`
+	if err := os.WriteFile(file, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Reset()
+	defer viper.Reset()
+	viper.SetConfigFile(file)
+	saved := logger
+	defer func() { logger = saved }()
+	if err := loadConfigFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	problems := validateConfig()
+	want := []string{"webhook 2 ", "webhook 3 "}
+	if len(problems) != len(want) {
+		t.Fatalf("problems %v, want one for each of %q", problems, want)
+	}
+	for i, p := range problems {
+		if !strings.Contains(p.msg, want[i]) {
+			t.Errorf("problem %q, want it about %s", p.String(), want[i])
+		}
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const defaultConfigDir = "conf.d"
+
+// Besides the main config file, webhooks (or any other settings) can come from:
+//
+//   - every *.yaml / *.yml file in a conf.d directory, by default next to the main config
+//     file, read in name order
+//   - files listed under an 'include' key, relative to the including file. Globs are
+//     allowed and included files can include others
+//
+// Settings from later files override earlier ones, except webhook lists, which are
+// concatenated so each team can own its own file
+
+type configSource struct {
+	file     string
+	webhooks int
+}
+
+// Where each webhook came from, in the same order as Config().webhooks
+
+var configSources []configSource
+
+// What loadConfigFiles changes, so a rejected reload can put it back
+
+type configFilesState struct {
+	settings map[string]interface{}
+	sources  []configSource
+}
+
+func saveConfigFiles() configFilesState {
+	return configFilesState{settings: viper.AllSettings(), sources: configSources}
+}
+
+func (s configFilesState) restore() {
+	_ = viper.ReadConfig(strings.NewReader("")) // empties the file settings, whatever the format
+	_ = viper.MergeConfigMap(s.settings)
+	configSources = s.sources
+}
+
+func loadConfigFiles() error {
+	configSources = nil
+
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return err
+		}
+	}
+
+	var hooks []interface{}
+	seen := map[string]bool{}
+
+	// The main file's own webhooks and includes come first
+
+	mainFile := viper.ConfigFileUsed()
+	if mainFile != "" {
+		seen[absPath(mainFile)] = true
+		hooks = appendWebhooks(hooks, mainFile, viper.Get("webhooks"))
+		for _, f := range expandIncludes(mainFile, viper.GetStringSlice("include")) {
+			if err := mergeConfigFile(f, &hooks, seen); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Then the conf.d fragments
+
+	dir := viper.GetString("configDir")
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(mainFile), defaultConfigDir)
+	}
+	for _, f := range configDirFiles(dir) {
+		if err := mergeConfigFile(f, &hooks, seen); err != nil {
+			return err
+		}
+	}
+
+	// Only replace the webhook list if other files contributed to it
+
+	if len(seen) > 1 || (mainFile == "" && len(seen) > 0) {
+		return viper.MergeConfigMap(map[string]interface{}{"webhooks": hooks})
+	}
+	return nil
+}
+
+func mergeConfigFile(file string, hooks *[]interface{}, seen map[string]bool) error {
+	abs := absPath(file)
+	if seen[abs] {
+		return nil // Already read, or an include loop
+	}
+	seen[abs] = true
+
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("config file '%s': %v", file, err)
+	}
+	logger.Debug("(loadConfigFiles) merging config", "file", file)
+
+	*hooks = appendWebhooks(*hooks, file, v.Get("webhooks"))
+
+	settings := v.AllSettings()
+	delete(settings, "webhooks")
+	delete(settings, "include")
+	if err := viper.MergeConfigMap(settings); err != nil {
+		return fmt.Errorf("config file '%s': %v", file, err)
+	}
+
+	for _, f := range expandIncludes(file, v.GetStringSlice("include")) {
+		if err := mergeConfigFile(f, hooks, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendWebhooks(hooks []interface{}, file string, list interface{}) []interface{} {
+	fileHooks, _ := list.([]interface{})
+	configSources = append(configSources, configSource{file: file, webhooks: len(fileHooks)})
+	return append(hooks, fileHooks...)
+}
+
+func expandIncludes(from string, includes []string) []string {
+	var files []string
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(from), include)
+		}
+		matches, err := filepath.Glob(include)
+		if err != nil || len(matches) == 0 {
+			logger.Warn("config include matched no files", "file", from, "include", include)
+			continue
+		}
+		files = append(files, matches...)
+	}
+	return files
+}
+
+func configDirFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, e := range entries {
+		if ext := filepath.Ext(e.Name()); !e.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+func absPath(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return file
+}
+
+// Describe a webhook by its position in the merged list and the file it came from
+
+func webhookRef(i int) string {
+	first := 0
+	for _, src := range configSources {
+		if i < first+src.webhooks {
+			return fmt.Sprintf("webhook %d (%s #%d)", i+1, src.file, i-first+1)
+		}
+		first += src.webhooks
+	}
+	return fmt.Sprintf("webhook %d", i+1)
+}
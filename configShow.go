@@ -0,0 +1,172 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// Show the effective (merged) config for 'config show', with the source of each value:
+// a flag, an environment variable, the config file that set it last, or the default
+
+func showConfig() {
+	files := readConfigSources()
+
+	fmt.Printf("%s\n", stringColorize("### Effective configuration", COLOR_HIGHLIGHT))
+	servers, serverList := viper.Get("nso").([]interface{})
+
+	for _, key := range schemaKeys(rootSchema, "") {
+		if strings.EqualFold(key, "webhooks") || serverList && strings.HasPrefix(key, "nso.") {
+			continue
+		}
+		value := viper.Get(key)
+		if secretKeys[strings.ToLower(lastKeyPart(key))] {
+			value = redactValue(key, value)
+		}
+		fmt.Printf("  %-22s %-40v %s\n", key, valueString(value), stringColorize(configValueSource(key, files), COLOR_HI_BLACK))
+	}
+
+	if serverList {
+		fmt.Printf("%s\n", stringColorize(fmt.Sprintf("### %d NSO servers", len(servers)), COLOR_HIGHLIGHT))
+		for i, server := range servers {
+			printConfigItem(fmt.Sprintf("server %d (%s)", i+1, configValueSource("nso", files)), server)
+		}
+	}
+
+	hooks, _ := viper.Get("webhooks").([]interface{})
+	fmt.Printf("%s\n", stringColorize(fmt.Sprintf("### %d webhook%s", len(hooks), pluralSuffix(len(hooks))), COLOR_HIGHLIGHT))
+	for i, hook := range hooks {
+		printConfigItem(webhookRef(i), hook)
+	}
+}
+
+func printConfigItem(label string, item interface{}) {
+	out, err := yaml.Marshal(redactSecrets(item))
+	if err != nil {
+		fmt.Printf("  %s: %v\n", label, err)
+		return
+	}
+	fmt.Printf("  %s\n", stringColorize(label, COLOR_WEBHOOK))
+	for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		fmt.Printf("    %s\n", line)
+	}
+}
+
+// The dotted names of every setting in the schema, sorted
+
+func schemaKeys(schema configSchema, prefix string) []string {
+	var keys []string
+	for name, node := range schema {
+		if node.kind == KIND_MAP || node.kind == KIND_MAP_OR_LIST {
+			keys = append(keys, schemaKeys(node.fields, prefix+name+".")...)
+		} else {
+			keys = append(keys, prefix+name)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func lastKeyPart(key string) string {
+	return key[strings.LastIndex(key, ".")+1:]
+}
+
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "-"
+	case string:
+		if v == "" {
+			return "-"
+		}
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, p := range v {
+			parts[i] = fmt.Sprint(p)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprint(value)
+}
+
+// Each config file read on its own, in merge order, to find which one set a value
+
+type configSourceFile struct {
+	file  string
+	viper *viper.Viper
+}
+
+func readConfigSources() []configSourceFile {
+	var files []configSourceFile
+	for _, src := range configSources {
+		v := viper.New()
+		v.SetConfigFile(src.file)
+		if err := v.ReadInConfig(); err == nil {
+			files = append(files, configSourceFile{file: src.file, viper: v})
+		}
+	}
+	return files
+}
+
+// Same precedence as viper: flag, environment, config file, default
+
+func configValueSource(key string, files []configSourceFile) string {
+	if flag, found := flagBindings[strings.ToLower(key)]; found && flag.Changed {
+		return "flag --" + flag.Name
+	}
+	env := "NSOEVENT_" + strings.ToUpper(key)
+	if _, found := os.LookupEnv(env); found {
+		return "env " + env
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		if files[i].viper.IsSet(key) {
+			return "file " + files[i].file
+		}
+	}
+	return "default"
+}
+
+func redactSecrets(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = redactValue(k, item)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[fmt.Sprint(k)] = redactValue(fmt.Sprint(k), item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = redactSecrets(item)
+		}
+		return out
+	}
+	return value
+}
+
+// Secret references (env:, file:, exec:) are safe to show, and more useful than a mask
+
+func redactValue(key string, value interface{}) interface{} {
+	if secretKeys[strings.ToLower(lastKeyPart(key))] {
+		if s := fmt.Sprint(value); !isSecretRef(s) {
+			return redactSecret(s)
+		}
+		return value
+	}
+	return redactSecrets(value)
+}
@@ -0,0 +1,131 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type OrderingMode int
+
+const (
+	ORDERING_NONE OrderingMode = iota
+	ORDERING_DEVICE
+	ORDERING_STREAM
+)
+
+func (o OrderingMode) String() string {
+	return [...]string{"none", "device", "stream"}[o]
+}
+
+func parseOrderingMode(s string) (OrderingMode, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return ORDERING_NONE, nil
+	case "device":
+		return ORDERING_DEVICE, nil
+	case "stream":
+		return ORDERING_STREAM, nil
+	}
+	return ORDERING_NONE, fmt.Errorf("unknown ordering mode '%s' (expected none, device or stream)", s)
+}
+
+// Work sharing a key is run one item at a time, in the order it was dispatched, while
+// work for different keys runs in parallel. Work can hold several keys (an event on several
+// devices) and then waits until it is at the head of every one of their queues. All of an
+// item's keys are queued in one step, so the queues agree on the order and can't deadlock.
+// Nothing runs for idle keys (devices), and their queues are removed as they drain
+
+type keyedWork struct {
+	keys    []string
+	work    func()
+	waiting int // queues where this work isn't yet at the head
+}
+
+type keyedDispatcher struct {
+	mu     sync.Mutex
+	queues map[string][]*keyedWork
+}
+
+func newKeyedDispatcher() *keyedDispatcher {
+	return &keyedDispatcher{queues: make(map[string][]*keyedWork)}
+}
+
+func (d *keyedDispatcher) dispatch(keys []string, work func()) {
+	w := &keyedWork{keys: keys, work: work}
+	d.mu.Lock()
+	for _, key := range keys {
+		if len(d.queues[key]) > 0 {
+			w.waiting++
+		}
+		d.queues[key] = append(d.queues[key], w)
+	}
+	ready := w.waiting == 0
+	d.mu.Unlock()
+
+	if ready {
+		go d.run(w)
+	}
+}
+
+// Run the work, then remove it from its queues. Whatever that leaves at the head of all
+// its own queues is ready: the first carries on in this goroutine, the rest get their own
+
+func (d *keyedDispatcher) run(w *keyedWork) {
+	for w != nil {
+		w.work()
+
+		var ready []*keyedWork
+		d.mu.Lock()
+		for _, key := range w.keys {
+			queue := d.queues[key]
+			queue[0] = nil
+			if queue = queue[1:]; len(queue) == 0 {
+				delete(d.queues, key)
+				continue
+			}
+			d.queues[key] = queue
+			next := queue[0]
+			if next.waiting--; next.waiting == 0 {
+				ready = append(ready, next)
+			}
+		}
+		d.mu.Unlock()
+
+		w = nil
+		for i, next := range ready {
+			if i == 0 {
+				w = next
+			} else {
+				go d.run(next)
+			}
+		}
+	}
+}
+
+// The partition keys for a notification. In device mode an event is keyed by each device
+// it touches, so it is ordered against every other event on any of those devices, and
+// events without any devices fall back to the stream so they still stay in order relative
+// to each other. Devices are per server, since different servers may well use the same
+// device names
+
+func (n *Notification) orderingKeys(sub streamSubscriber, mode OrderingMode) []string {
+	if mode == ORDERING_DEVICE && len(n.Devices) > 0 {
+		devices := append([]string(nil), n.Devices...)
+		sort.Strings(devices)
+		devices = slices.Compact(devices)
+		keys := make([]string, len(devices))
+		for i, device := range devices {
+			keys[i] = "device:" + n.Server + ":" + device
+		}
+		return keys
+	}
+	return []string{"stream:" + sub.stream.label()}
+}
@@ -0,0 +1,100 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Device ordering: an event on several devices runs after the earlier events on each of
+// them and before the later ones, while unrelated devices don't wait. Each piece of work
+// reports when it starts and then holds its keys until the test releases it
+
+func TestKeyedDispatcher(t *testing.T) {
+	d := newKeyedDispatcher()
+	started := make(chan string, 10)
+	release := map[string]chan struct{}{}
+	dispatch := func(name string, keys ...string) {
+		done := make(chan struct{})
+		release[name] = done
+		d.dispatch(keys, func() {
+			started <- name
+			<-done
+		})
+	}
+
+	// Starts expects exactly these to start next, in any order, and nothing else
+
+	starts := func(step string, want ...string) {
+		t.Helper()
+		var got []string
+		for range want {
+			select {
+			case name := <-started:
+				got = append(got, name)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: started %v, want %v", step, got, want)
+			}
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: started %v, want %v", step, got, want)
+		}
+		select {
+		case name := <-started:
+			t.Fatalf("%s: %s started too, want only %v", step, name, want)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	dispatch("A1", "A")
+	dispatch("B1", "B")
+	dispatch("AB", "A", "B")
+	dispatch("A2", "A")
+	dispatch("A3", "A")
+	dispatch("C1", "C")
+
+	starts("dispatched", "A1", "B1", "C1")
+	close(release["C1"])
+	starts("C1 done")
+	close(release["A1"])
+	starts("A1 done, B1 still running")
+	close(release["B1"])
+	starts("A1 and B1 done", "AB")
+	close(release["AB"])
+	starts("AB done", "A2")
+	close(release["A2"])
+	starts("A2 done", "A3")
+	close(release["A3"])
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		d.mu.Lock()
+		left := len(d.queues)
+		d.mu.Unlock()
+		if left == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d queues left after draining", left)
+		}
+	}
+}
+
+func TestOrderingKeys(t *testing.T) {
+	sub := streamSubscriber{stream: &Stream{Name: "NETCONF", server: "nso1"}}
+	n := &Notification{Server: "nso1", Devices: []string{"R2", "R1", "R2"}}
+	if got, want := n.orderingKeys(sub, ORDERING_DEVICE), []string{"device:nso1:R1", "device:nso1:R2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("device keys %v, want %v", got, want)
+	}
+	n.Devices = nil
+	if got, want := n.orderingKeys(sub, ORDERING_DEVICE), []string{"stream:" + sub.stream.label()}; !reflect.DeepEqual(got, want) {
+		t.Errorf("no devices: keys %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// With --dry-run, events are decoded and matched as usual, but instead of sending webhooks
+// each event gets a report: every webhook on the stream, whether it would fire and why,
+// and the payload it would be sent. Nothing is delivered and no checkpoints are recorded
+
+var dryRunLock sync.Mutex // events are handled concurrently, so keep each report whole
+
+func (sub streamSubscriber) dryRun(n *Notification, body []byte) {
+	var report strings.Builder
+	fires := 0
+
+	fmt.Fprintf(&report, "%s [%s] %s %s\n", stringColorize("### dry run", COLOR_HEADINGS), stringColorize(sub.stream.label(), COLOR_STREAM),
+		stringColorize(n.EventName, COLOR_EVENT), n.EventTime.Format("2006-01-02T15:04:05.000Z07:00"))
+
+	hooks := sub.stream.webhooks()
+	if len(hooks) == 0 {
+		report.WriteString("  no webhooks for this stream\n")
+	}
+	for _, hook := range hooks {
+		if hook.report(&report, "webhook "+hook.target(), n, body) {
+			fires++
+		}
+	}
+
+	if fires > 0 {
+		var payload bytes.Buffer
+		if err := json.Indent(&payload, body, "  ", "  "); err != nil {
+			payload.Write(body)
+		}
+		fmt.Fprintf(&report, "  payload:\n  %s\n", strings.TrimSpace(payload.String()))
+	}
+
+	dryRunLock.Lock()
+	defer dryRunLock.Unlock()
+	fmt.Print(report.String())
+}
+
+// Whether the webhook would fire for an event, and why, one filter condition per line
+
+func (hook *webhook) report(report *strings.Builder, name string, n *Notification, body []byte) bool {
+	fires := hook.shouldFire(n, body)
+	verdict := stringColorize("would fire", COLOR_HI_GREEN)
+	if !fires {
+		verdict = stringColorize("would NOT fire", COLOR_HI_RED)
+	}
+	fmt.Fprintf(report, "  %s: %s\n", stringColorize(name, COLOR_URL), verdict)
+
+	if hook.Disable {
+		report.WriteString("    disabled\n")
+	}
+	if hook.Filter == nil {
+		report.WriteString("    no filter, every event matches\n")
+	}
+	for _, check := range hook.filterChecks(n, body) {
+		result := stringColorize("passed", COLOR_GREEN)
+		if !check.passed {
+			result = stringColorize("failed", COLOR_RED)
+		}
+		fmt.Fprintf(report, "    %s: %s (%s)\n", check.condition, result, check.reason)
+	}
+	if hook.coalescer != nil {
+		fmt.Fprintf(report, "    would be coalesced (debounce %v, dedupe %v)\n", hook.Debounce, hook.Dedupe != nil)
+	}
+	return fires
+}
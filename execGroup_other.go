@@ -0,0 +1,14 @@
+//go:build !unix
+
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import "os/exec"
+
+// Process groups are a unix thing; elsewhere the command runs as is
+
+func ownProcessGroup(_ *exec.Cmd) {}
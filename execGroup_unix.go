@@ -0,0 +1,20 @@
+//go:build unix
+
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Run an exec sink command in its own process group, out of reach of the terminal's
+// signals, so it can finish while nsoevent drains at shutdown
+
+func ownProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
@@ -0,0 +1,119 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The filter test command: decode sample notifications from a file (or stdin) with the
+// handler for each webhook's stream, and report how each filter condition fares. Returns
+// an error if no webhook would fire, so it can be scripted
+
+func filterTest(hookNumber int, streamName string, eventPath string) error {
+	notifications, err := readTestNotifications(eventPath)
+	if err != nil {
+		return fmt.Errorf("(filterTest) %v", err)
+	}
+
+	// Pick the webhooks to test: one by number (as config validate counts them), or all of
+	// them, or those for a particular stream
+
+	if hookNumber < 0 || hookNumber > len(Config().webhooks) {
+		return fmt.Errorf("(filterTest) no webhook %d, the config has %d", hookNumber, len(Config().webhooks))
+	}
+	hookNumbers := []int{}
+	for i, hook := range Config().webhooks {
+		if hookNumber == i+1 || hookNumber == 0 && (streamName == "" || fuzzyNameMatch(hook.Stream, streamName)) {
+			hookNumbers = append(hookNumbers, i)
+		}
+	}
+	if len(hookNumbers) == 0 {
+		return fmt.Errorf("(filterTest) no webhooks to test")
+	}
+	if err := Config().webhooks.check(); err != nil {
+		logger.Warn("(filterTest) " + err.Error())
+	}
+
+	fires := 0
+	for e, sample := range notifications {
+		for _, i := range hookNumbers {
+			hook := Config().webhooks[i]
+			stream := hook.Stream
+			if streamName != "" {
+				stream = streamName
+			}
+
+			// Decode with the stream's handler, through a stand-in subscriber
+
+			sub := &streamSubscriber{
+				server:  &NsoServer{name: "filter-test"},
+				stream:  &Stream{Name: stream},
+				url:     &url.URL{Host: "filter-test"},
+				handler: (*Notification).handlerDefault,
+			}
+			subscriberList{sub}.registerHandlers()
+
+			n := newNotification()
+			n.EventTime = sample.EventTime
+			n.Inner = sample.Inner
+			body, ok := sub.handleNotification(n)
+			if !ok {
+				continue
+			}
+
+			var report strings.Builder
+			fmt.Fprintf(&report, "%s %d [%s] %s\n", stringColorize("### event", COLOR_HEADINGS), e+1,
+				stringColorize(stream, COLOR_STREAM), stringColorize(n.EventName, COLOR_EVENT))
+			if hook.report(&report, webhookRef(i)+" "+hook.target(), n, body) {
+				fires++
+			}
+			fmt.Print(report.String())
+		}
+	}
+
+	if fires == 0 {
+		return fmt.Errorf("no webhook would fire")
+	}
+	fmt.Printf("\n%s webhook%s would fire\n", stringColorize(strconv.Itoa(fires), COLOR_HIGHLIGHT), pluralSuffix(fires))
+	return nil
+}
+
+// One or more <notification> elements, as NSO sends them. "-" reads stdin
+
+func readTestNotifications(path string) ([]*Notification, error) {
+	var reader io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	var notifications []*Notification
+	d := xml.NewDecoder(reader)
+	for {
+		n := newNotification()
+		if err := d.Decode(n); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding notification %d: %v", len(notifications)+1, err)
+		}
+		notifications = append(notifications, n)
+	}
+	if len(notifications) == 0 {
+		return nil, fmt.Errorf("no notifications found in %s", path)
+	}
+	return notifications, nil
+}
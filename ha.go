@@ -0,0 +1,263 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHACheckInterval = 10 * time.Second
+
+	requestURLHAMode   = "/data/tailf-ncs:high-availability/status/mode"
+	requestURLRaftRole = "/data/tailf-ncs:ha-raft/status/role"
+)
+
+// NSO HA clusters. A server with more than one node URL is treated as a cluster: the node
+// reporting itself as primary (built-in HA mode, or the HA Raft leader) is the one used,
+// and its role is checked every haCheckInterval. When the node stops being primary, or a
+// stream is lost and another node now reports itself primary, the subscribers wait for the
+// new primary, then subscribe there, replaying from their checkpoints so nothing in between
+// is missed. A stream lost while its node is still primary is simply subscribed to again
+//
+//   nso:
+//     nodes:
+//       - https://nso-1.example.com:8888
+//       - https://nso-2.example.com:8888
+//     haCheckInterval: 10s
+
+type haState struct {
+	mu         sync.Mutex
+	generation int           // bumped on every failover
+	changed    chan struct{} // closed on every failover
+}
+
+func (s *NsoServer) clustered() bool {
+	return len(s.target.nodes) > 1
+}
+
+// The current failover generation, and a channel that closes when it changes
+
+func (s *NsoServer) haWatch() (int, <-chan struct{}) {
+	if s.ha == nil {
+		return 0, nil
+	}
+	s.ha.mu.Lock()
+	defer s.ha.mu.Unlock()
+	return s.ha.generation, s.ha.changed
+}
+
+func isPrimaryRole(role string) bool {
+	switch role {
+	case "primary", "master", "leader":
+		return true
+	}
+	return false
+}
+
+// Ask a node for its HA role. A node without HA configured reports "none"
+
+func (s *NsoServer) nodeRole(node nsoNode) (string, error) {
+	_, root := s.connection()
+	if root == "" {
+		root = "/restconf"
+	}
+
+	for _, path := range []string{requestURLHAMode, requestURLRaftRole} {
+		ctx, cancel := context.WithTimeout(context.Background(), s.target.connectTimeout)
+		req, err := http.NewRequestWithContext(ctx, "GET", node.apiUrl+root+path, nil)
+		if err != nil {
+			cancel()
+			return "", err
+		}
+		req.Header.Set("Accept", "application/yang-data+xml")
+
+		resp, err := s.do(req)
+		if err != nil {
+			cancel()
+			return "", err
+		}
+		data, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		cancel()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotFound, http.StatusNoContent:
+			continue
+		default:
+			return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+
+		var leaf struct {
+			Value string `xml:",chardata"`
+		}
+		if err := xml.Unmarshal(data, &leaf); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(leaf.Value), nil
+	}
+	return "none", nil
+}
+
+// Find the node that reports itself primary, if any
+
+func (s *NsoServer) findPrimary() (nsoNode, bool) {
+	for _, node := range s.target.nodes {
+		role, err := s.nodeRole(node)
+		if err != nil {
+			logger.Warn("(NSOServer:ha) node unavailable", "server", s.name, "url", node.apiUrl, "err", err)
+			continue
+		}
+		logger.Debug("(NSOServer:ha) node role", "server", s.name, "url", node.apiUrl, "role", role)
+		if isPrimaryRole(role) {
+			return node, true
+		}
+	}
+	return nsoNode{}, false
+}
+
+// Switch to whichever node is primary
+
+func (s *NsoServer) selectPrimary() error {
+	node, found := s.findPrimary()
+	if !found {
+		return fmt.Errorf("(NSOServer:ha) no primary node found for NSO server %s", s.name)
+	}
+	if _, root := s.connection(); node != s.currentNode() || root == "" {
+		logger.Info("(NSOServer:ha) using primary node", "server", s.name, "url", node.apiUrl)
+	}
+	s.useNode(node)
+	return nil
+}
+
+// Find the primary again and refresh everything that depends on the node
+
+func (s *NsoServer) reconnect() error {
+	if err := s.selectPrimary(); err != nil {
+		return err
+	}
+	s.setRootResource("") // The root resource request is relative to the node itself
+	if err := s.getRootResource(); err != nil {
+		return err
+	}
+	return s.getStreamList()
+}
+
+// Poll the role of the node in use, failing over if it's no longer primary. A node that
+// can't be reached is left to the subscribers, whose streams will drop
+
+func (g *subscriberGroup) watchRole(s *NsoServer) {
+	ticker := time.NewTicker(s.target.haInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.ctx.Done():
+			return
+		case <-ticker.C:
+			generation, _ := s.haWatch()
+			node := s.currentNode()
+			role, err := s.nodeRole(node)
+			if err != nil || isPrimaryRole(role) {
+				continue
+			}
+			logger.Warn("(NSOServer:ha) node is no longer primary", "server", s.name, "url", node.apiUrl, "role", role)
+			g.failover(s, generation)
+		}
+	}
+}
+
+// A subscriber's stream was lost. If the server has failed over since the subscriber
+// started, or another node has become primary, it follows the rest of the server's
+// subscribers to the new primary. Otherwise the node is still primary (or none is yet)
+// and only this stream is subscribed to again, after a pause. Returns false if the
+// subscriber should stop
+
+func (g *subscriberGroup) streamLost(sub *streamSubscriber, generation int) bool {
+	s := sub.server
+	if current, _ := s.haWatch(); current != generation {
+		return sub.resolveStream()
+	}
+
+	if node, found := s.findPrimary(); found && node != s.currentNode() {
+		logger.Warn("(NSOServer:ha) new primary node, failing over", "server", s.name, "url", node.apiUrl)
+		return g.failover(s, generation) && sub.resolveStream()
+	}
+
+	logger.Info("(NSOServer:ha) primary unchanged, resubscribing", "stream", sub.stream.label())
+	select {
+	case <-sub.done():
+		return false
+	case <-time.After(s.target.haInterval):
+	}
+	return true
+}
+
+// Move a server's subscribers to the new primary, unless another subscriber (or the role
+// watcher) already has since the given generation. Retries until a primary turns up or
+// the subscribers are stopped
+
+func (g *subscriberGroup) failover(s *NsoServer, generation int) bool {
+	s.ha.mu.Lock()
+	defer s.ha.mu.Unlock()
+
+	if s.ha.generation != generation {
+		return true
+	}
+
+	for {
+		g.reloadLock.Lock()
+		err := s.reconnect()
+		if err == nil {
+			Config().webhooks.link(g.servers)
+		}
+		g.reloadLock.Unlock()
+		if err == nil {
+			break
+		}
+
+		logger.Error("(NSOServer:ha) failover", "server", s.name, "err", err)
+		select {
+		case <-g.ctx.Done():
+			return false
+		case <-time.After(s.target.haInterval):
+		}
+	}
+
+	s.ha.generation++
+	close(s.ha.changed)
+	s.ha.changed = make(chan struct{})
+	metricFailovers.WithLabelValues(s.name).Inc()
+	return true
+}
+
+// Point a subscriber at its stream on the (new) primary
+
+func (sub *streamSubscriber) resolveStream() bool {
+	for _, stream := range sub.server.streams().Stream {
+		if stream.Name != sub.stream.Name {
+			continue
+		}
+		for _, a := range stream.Access {
+			if a.EncodingType == ENCODING_XML {
+				streamSubscriberListLock.Lock()
+				sub.stream = stream
+				sub.url = a.LocationURL
+				streamSubscriberListLock.Unlock()
+				return true
+			}
+		}
+	}
+	logger.Error("(NSOServer:ha) stream not found on the new primary", "stream", sub.stream.label())
+	return false
+}
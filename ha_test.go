@@ -0,0 +1,110 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A two-node cluster of mock NSOs, each reporting whatever HA role the test gives it
+
+type haTestCluster struct {
+	mu    sync.Mutex
+	roles map[string]string // by node URL
+	nodes []*httptest.Server
+}
+
+func newHATestCluster(t *testing.T) *haTestCluster {
+	events, err := loadMockEvents(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMockNSO(events, 0)
+	c := &haTestCluster{roles: map[string]string{}}
+	for i := 0; i < 2; i++ {
+		var node *httptest.Server
+		node = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/restconf"+requestURLHAMode {
+				c.mu.Lock()
+				role := c.roles[node.URL]
+				c.mu.Unlock()
+				fmt.Fprintf(w, `<mode xmlns="http://tail-f.com/ns/ncs">%s</mode>`, role)
+				return
+			}
+			m.serve(w, r)
+		}))
+		t.Cleanup(node.Close)
+		c.nodes = append(c.nodes, node)
+	}
+	return c
+}
+
+func (c *haTestCluster) setRoles(roles ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, role := range roles {
+		c.roles[c.nodes[i].URL] = role
+	}
+}
+
+// A lost stream only fails the server over when another node has become primary; while
+// the node in use is still primary just that stream is subscribed to again
+
+func TestStreamLost(t *testing.T) {
+	c := newHATestCluster(t)
+	c.setRoles("primary", "secondary")
+
+	target := &nsoInfo{name: "nso1", haInterval: 10 * time.Millisecond, connectTimeout: 5 * time.Second,
+		readTimeout: 5 * time.Second}
+	for _, node := range c.nodes {
+		target.nodes = append(target.nodes, nsoNode{apiUrl: node.URL})
+	}
+	s := newNSOServer(target)
+	if err := s.reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g := &subscriberGroup{ctx: ctx, servers: nsoServers{s}}
+	sub := &streamSubscriber{server: s, stream: &Stream{Name: "NETCONF", server: "nso1"}, done: ctx.Done}
+	if !sub.resolveStream() {
+		t.Fatal("NETCONF stream not found")
+	}
+
+	tests := []struct {
+		name       string
+		roles      []string
+		generation int // the generation the subscriber started in
+		node       int // the node subscribed to afterwards
+		failovers  int
+	}{
+		{"primary unchanged", []string{"primary", "secondary"}, 0, 0, 0},
+		{"no primary yet", []string{"secondary", "secondary"}, 0, 0, 0},
+		{"new primary", []string{"secondary", "primary"}, 0, 1, 1},
+		{"already failed over", []string{"secondary", "primary"}, 0, 1, 1},
+		{"stream lost on the new primary", []string{"secondary", "primary"}, 1, 1, 1},
+	}
+	for _, test := range tests {
+		c.setRoles(test.roles...)
+		if !g.streamLost(sub, test.generation) {
+			t.Fatalf("%s: subscriber stopped", test.name)
+		}
+		if generation, _ := s.haWatch(); generation != test.failovers {
+			t.Errorf("%s: %d failovers, want %d", test.name, generation, test.failovers)
+		}
+		if want := c.nodes[test.node].Listener.Addr().(*net.TCPAddr).Port; sub.url.Port() != strconv.Itoa(want) {
+			t.Errorf("%s: stream at %s, want node %d on port %d", test.name, sub.url.Host, test.node+1, want)
+		}
+	}
+}
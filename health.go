@@ -0,0 +1,154 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Liveness and readiness for container deployments. A subscriber is considered healthy while
+// its stream is connected and, unless the keepalive threshold is set to 0, something (an event
+// or an SSE keepalive) has been read from the stream within that threshold
+
+// NSO sends keepalives on an idle stream, so even a quiet one is read from well within this.
+// A connection that has gone silent for longer has most likely died without being closed
+
+const defaultKeepaliveThreshold = 5 * time.Minute
+
+type subscriberHealth struct {
+	mu           sync.Mutex
+	connected    bool
+	connectedAt  time.Time
+	lastActivity time.Time
+	events       int
+}
+
+func (h *subscriberHealth) up() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connected = true
+	h.connectedAt = time.Now()
+	h.lastActivity = h.connectedAt
+}
+
+func (h *subscriberHealth) down() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connected = false
+}
+
+func (h *subscriberHealth) event() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events++
+}
+
+func (h *subscriberHealth) activity() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastActivity = time.Now()
+}
+
+// Wrap the stream reader so any bytes read count as activity
+
+type activityReader struct {
+	io.ReadCloser
+	health *subscriberHealth
+}
+
+func (h *subscriberHealth) track(r io.ReadCloser) io.ReadCloser {
+	return &activityReader{ReadCloser: r, health: h}
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.health.activity()
+	}
+	return n, err
+}
+
+type streamHealth struct {
+	Server       string     `json:"server"`
+	Stream       string     `json:"stream"`
+	URL          string     `json:"url"`
+	Connected    bool       `json:"connected"`
+	ConnectedAt  *time.Time `json:"connectedAt,omitempty"`
+	LastActivity *time.Time `json:"lastActivity,omitempty"`
+	Events       int        `json:"events"`
+	Ready        bool       `json:"ready"`
+}
+
+type healthReport struct {
+	Status  string         `json:"status"`
+	Streams []streamHealth `json:"streams"`
+}
+
+// Build a report from the current subscriber list. Ready means at least one subscriber and
+// every subscriber connected and recently active
+
+func newHealthReport() (*healthReport, bool) {
+	streamSubscriberListLock.RLock()
+	defer streamSubscriberListLock.RUnlock()
+
+	report := &healthReport{Streams: []streamHealth{}}
+	ready := len(streamSubscriberList) > 0
+	now := time.Now()
+
+	for _, sub := range streamSubscriberList {
+		sub.health.mu.Lock()
+		entry := streamHealth{
+			Server:    sub.stream.server,
+			Stream:    sub.stream.Name,
+			URL:       sub.url.String(),
+			Connected: sub.health.connected,
+			Events:    sub.health.events,
+		}
+		if !sub.health.connectedAt.IsZero() {
+			connectedAt, lastActivity := sub.health.connectedAt, sub.health.lastActivity
+			entry.ConnectedAt = &connectedAt
+			entry.LastActivity = &lastActivity
+		}
+		entry.Ready = entry.Connected &&
+			(Config().keepaliveThreshold == 0 || now.Sub(sub.health.lastActivity) <= Config().keepaliveThreshold)
+		sub.health.mu.Unlock()
+
+		ready = ready && entry.Ready
+		report.Streams = append(report.Streams, entry)
+	}
+	return report, ready
+}
+
+func healthzHandler(w http.ResponseWriter, _ *http.Request) {
+	report, _ := newHealthReport()
+	report.Status = "alive"
+	writeHealthReport(w, http.StatusOK, report)
+}
+
+func readyzHandler(w http.ResponseWriter, _ *http.Request) {
+	report, ready := newHealthReport()
+	status := http.StatusOK
+	report.Status = "ready"
+	if !ready {
+		status = http.StatusServiceUnavailable
+		report.Status = "not ready"
+	}
+	writeHealthReport(w, status, report)
+}
+
+func writeHealthReport(w http.ResponseWriter, status int, report *healthReport) {
+	body, err := jsonMarshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,60 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// A connected stream that has gone quiet for longer than the keepalive threshold is
+// reported not ready, and ready again once something is read from it
+
+func TestReadyzKeepalive(t *testing.T) {
+	sub := &streamSubscriber{stream: &Stream{Name: "NETCONF", server: "nso1"}, url: &url.URL{Host: "nso1.example.com:8080"},
+		health: &subscriberHealth{}}
+
+	streamSubscriberListLock.Lock()
+	saved := streamSubscriberList
+	streamSubscriberList = subscriberList{sub}
+	streamSubscriberListLock.Unlock()
+	defer func() {
+		streamSubscriberListLock.Lock()
+		streamSubscriberList = saved
+		streamSubscriberListLock.Unlock()
+	}()
+
+	readyz := func() int {
+		w := httptest.NewRecorder()
+		readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code
+	}
+
+	tests := []struct {
+		name      string
+		update    func(h *subscriberHealth)
+		threshold time.Duration
+		status    int
+	}{
+		{"not connected", func(h *subscriberHealth) {}, defaultKeepaliveThreshold, http.StatusServiceUnavailable},
+		{"connected", func(h *subscriberHealth) { h.up() }, defaultKeepaliveThreshold, http.StatusOK},
+		{"no activity", func(h *subscriberHealth) { h.lastActivity = time.Now().Add(-defaultKeepaliveThreshold - time.Second) },
+			defaultKeepaliveThreshold, http.StatusServiceUnavailable},
+		{"no activity, check off", func(h *subscriberHealth) {}, 0, http.StatusOK},
+		{"keepalive read", func(h *subscriberHealth) { h.activity() }, defaultKeepaliveThreshold, http.StatusOK},
+		{"disconnected", func(h *subscriberHealth) { h.down() }, defaultKeepaliveThreshold, http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		test.update(sub.health)
+		liveConfig.Store(&configuration{noColor: true, keepaliveThreshold: test.threshold})
+		if status := readyz(); status != test.status {
+			t.Errorf("%s: /readyz %d, want %d", test.name, status, test.status)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Optional HTTP listeners for pprof, metrics and health checks. Endpoints configured with the same port
+// share a single listener
+
+func startHTTPServers() {
+	muxes := map[int]*http.ServeMux{}
+	muxFor := func(port int) *http.ServeMux {
+		if _, found := muxes[port]; !found {
+			muxes[port] = http.NewServeMux()
+		}
+		return muxes[port]
+	}
+
+	if Config().profilingPort != 0 {
+		mux := muxFor(Config().profilingPort)
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	if Config().metricsPort != 0 {
+		muxFor(Config().metricsPort).Handle("/metrics", metricsHandler())
+	}
+
+	if Config().healthPort != 0 {
+		mux := muxFor(Config().healthPort)
+		mux.HandleFunc("/healthz", healthzHandler)
+		mux.HandleFunc("/readyz", readyzHandler)
+	}
+
+	for port, mux := range muxes {
+		go func(port int, mux *http.ServeMux) {
+			if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+				logger.Error("HTTP listener failed", "port", port, "err", err)
+			}
+		}(port, mux)
+	}
+}
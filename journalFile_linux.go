@@ -0,0 +1,56 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// A journal entry too big for a datagram is written to an unlinked file and the file's
+// descriptor sent instead, as sd_journal_send does. journald only accepts files from
+// /dev/shm, /tmp or /var/tmp
+
+func (c *syslogConn) writeJournalFile(entry []byte) error {
+	conn, ok := c.conn.(*net.UnixConn)
+	if !ok {
+		return errors.New("journal entry too large for a datagram")
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp("/dev/shm", "nsoevent-journal-")
+	if err != nil {
+		if file, err = os.CreateTemp("/tmp", "nsoevent-journal-"); err != nil {
+			return err
+		}
+	}
+	defer file.Close()
+	_ = os.Remove(file.Name())
+
+	if _, err := file.Write(entry); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// WriteMsgUnix refuses a connected datagram socket, so the descriptor goes by hand
+	var sendErr error
+	err = raw.Write(func(fd uintptr) bool {
+		sendErr = syscall.Sendmsg(int(fd), nil, syscall.UnixRights(int(file.Fd())), nil, 0)
+		return sendErr != syscall.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+	return sendErr
+}
@@ -0,0 +1,83 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// journald: fields in a datagram, or in a file passed over the socket when too big for one
+
+func TestJournald(t *testing.T) {
+	path := syslogSocketPath(t)
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	hook := syslogTestHook(t, &SyslogSink{Network: "journald", Address: path}, nil)
+	sub, body := publishTestEvent(t)
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatal(err)
+	}
+	fields["padding"] = strings.Repeat("x", 1<<20)
+	large, err := jsonMarshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buffer, oob := make([]byte, 65536), make([]byte, 1024)
+	for _, payload := range [][]byte{body, large} {
+		if err := hook.sink.send(sub, payload); err != nil {
+			t.Fatal(err)
+		}
+		_ = listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, oobn, _, _, err := listener.ReadMsgUnix(buffer, oob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := buffer[:n]
+
+		if oobn > 0 {
+			messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+			if err != nil || len(messages) != 1 {
+				t.Fatalf("control messages %v: %v", messages, err)
+			}
+			fds, err := syscall.ParseUnixRights(&messages[0])
+			if err != nil || len(fds) != 1 {
+				t.Fatalf("descriptors %v: %v", fds, err)
+			}
+			file := os.NewFile(uintptr(fds[0]), "journal")
+			entry, err = io.ReadAll(file)
+			_ = file.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if large := len(payload) > 65536; large != (oobn > 0) {
+			t.Errorf("%d byte payload: passed as a file %v, want %v", len(payload), oobn > 0, large)
+		}
+		for _, want := range []string{"MESSAGE=netconf-config-change by jenkins@10.20.0.15", "\nPRIORITY=5\n",
+			"\nNSOEVENT_STREAM=NETCONF\n", "\nNSOEVENT_DEVICES=ATX_PE_1,ATX_RTR_Lamar,CT_RTR_McCampbell\n",
+			"\nNSOEVENT_PAYLOAD=" + string(bytes.TrimSpace(payload)) + "\n"} {
+			if !bytes.Contains(entry, []byte(want)) {
+				t.Errorf("%d byte payload: entry missing %.80q", len(payload), want)
+			}
+		}
+	}
+}
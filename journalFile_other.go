@@ -0,0 +1,16 @@
+//go:build !linux
+
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import "errors"
+
+// The journal is Linux only, so there's nowhere to pass a file to
+
+func (c *syslogConn) writeJournalFile(_ []byte) error {
+	return errors.New("journal entry too large for a datagram")
+}
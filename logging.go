@@ -6,21 +6,219 @@ Created: 21-Sep-2020
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
-var debugOutput = false
+// Structured, leveled logging. Component fields are attached as attributes, using the keys
+// below so that both output formats agree:
+//
+//   stream     event stream name
+//   eventTime  NSO event timestamp
+//   event      event name
+//   webhook    webhook target URL
+//
+// The console handler reproduces the original colorized output, the JSON handler is meant
+// for log shippers. Trace is a level below debug for full request/response dumps. Credentials are
+// never logged, see redactAttr
 
-func enableDebug() {
-	debugOutput = true
-	fmt.Println(stringColorize("Debug output enabled", COLOR_RED))
+const (
+	levelTrace = slog.LevelDebug - 4
+
+	logFormatConsole = "console"
+	logFormatJSON    = "json"
+)
+
+var (
+	logger    = slog.New(newConsoleHandler(os.Stdout, slog.LevelInfo))
+	logOutput = os.Stdout // stderr when events go to stdout
+)
+
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "trace":
+		return levelTrace, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level '%s' (expected trace, debug, info, warn or error)", s)
 }
 
-/*
- * Logging debug output to default for now
- */
-func debugMsgf(msg string, v ...interface{}) {
-	if debugOutput {
-		fmt.Printf(msg, v...)
+func initLogging(format string, level slog.Level) error {
+	var handler slog.Handler
+	options := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && a.Value.Any().(slog.Level) == levelTrace {
+				a.Value = slog.StringValue("TRACE")
+			}
+			return redactAttr(a)
+		},
+	}
+
+	switch strings.ToLower(format) {
+	case "", logFormatConsole:
+		handler = newConsoleHandler(logOutput, level)
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(logOutput, options)
+	default:
+		return fmt.Errorf("unknown log format '%s' (expected console or json)", format)
+	}
+
+	logger = slog.New(handler)
+	return nil
+}
+
+func debugEnabled() bool {
+	return logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+func traceEnabled() bool {
+	return logger.Enabled(context.Background(), levelTrace)
+}
+
+func logTrace(msg string, args ...any) {
+	logger.Log(context.Background(), levelTrace, msg, args...)
+}
+
+//**********
+// Colorized console handler
+//**********
+//
+// Renders records the way nsoevent always has:
+//
+//   [stream] eventTime WARNING: message key=value ...
+//
+// with the stream and event time pulled out in front of the message when present
+
+type consoleHandler struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  slog.Leveler
+	attrs  []slog.Attr
+	prefix string
+}
+
+func newConsoleHandler(out io.Writer, level slog.Leveler) *consoleHandler {
+	return &consoleHandler{mu: new(sync.Mutex), out: out, level: level}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr(nil), h.attrs...), qualifyAttrs(h.prefix, attrs)...)
+	return &h2
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+func qualifyAttrs(prefix string, attrs []slog.Attr) []slog.Attr {
+	if prefix == "" {
+		return attrs
+	}
+	qualified := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		qualified[i] = slog.Attr{Key: prefix + a.Key, Value: a.Value}
+	}
+	return qualified
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := append([]slog.Attr(nil), h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, qualifyAttrs(h.prefix, []slog.Attr{a})...)
+		return true
+	})
+
+	var line, stream, eventTime strings.Builder
+	var rest []slog.Attr
+	for _, a := range attrs {
+		a = redactAttr(a)
+		switch a.Key {
+		case "stream":
+			stream.WriteString("[" + stringColorize(a.Value.String(), COLOR_STREAM) + "] ")
+		case "eventTime":
+			value := a.Value.String()
+			if t, ok := a.Value.Any().(time.Time); ok {
+				value = t.Format(time.RFC3339)
+			}
+			eventTime.WriteString(stringColorize(value, COLOR_HIGHLIGHT) + " ")
+		default:
+			rest = append(rest, a)
+		}
+	}
+
+	line.WriteString(stream.String())
+	line.WriteString(eventTime.String())
+	switch {
+	case r.Level >= slog.LevelError:
+		line.WriteString(stringColorize("ERROR", COLOR_ERROR) + ": ")
+	case r.Level >= slog.LevelWarn:
+		line.WriteString(stringColorize("WARNING", COLOR_WARNING) + ": ")
+	}
+
+	// Attributes go at the end of the first line of a multi-line message
+
+	message, more, _ := strings.Cut(r.Message, "\n")
+	line.WriteString(message)
+	for _, a := range rest {
+		line.WriteString(" " + a.Key + "=" + stringColorize(consoleValue(a.Value), consoleColor(a.Key)))
+	}
+	if more != "" {
+		line.WriteString("\n" + strings.TrimSuffix(more, "\n"))
+	}
+	line.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, line.String())
+	return err
+}
+
+// Multi-line values (request/response bodies) start on their own line, anything else with
+// spaces is quoted
+
+func consoleValue(v slog.Value) string {
+	s := v.Resolve().String()
+	if strings.Contains(s, "\n") {
+		return "\n" + s
+	}
+	if s == "" || strings.ContainsAny(s, " \t") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+func consoleColor(key string) int {
+	switch key {
+	case "webhook", "url":
+		return COLOR_URL
+	case "event":
+		return COLOR_EVENT
+	case "err", "error":
+		return COLOR_ERROR
 	}
+	return COLOR_HIGHLIGHT
 }
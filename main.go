@@ -5,11 +5,15 @@ Created: 21-Sep-2020
 
 package main
 
+import "os"
+
 const (
 	programName    = "nsoevent"
 	programVersion = "0.1.0(alpha)"
 )
 
 func main() {
-	_ = newBaseCmd().Execute()
+	if err := newBaseCmd().Execute(); err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,117 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics, exported on /metrics when a metrics port is configured
+
+var (
+	metricEventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: programName,
+		Name:      "events_received_total",
+		Help:      "Events received, by stream and event name",
+	}, []string{"stream", "event"})
+
+	metricHandlerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: programName,
+		Name:      "handler_errors_total",
+		Help:      "Events the stream handler failed to decode",
+	}, []string{"stream"})
+
+	metricWebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: programName,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook deliveries, by target URL (or sink) and HTTP status code ('error' if no response, 'ok' from other sinks)",
+	}, []string{"url", "code"})
+
+	metricWebhookLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: programName,
+		Name:      "webhook_delivery_duration_seconds",
+		Help:      "Time taken to deliver a webhook, by target URL (or sink)",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"url"})
+
+	metricReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: programName,
+		Name:      "subscriber_reconnects_total",
+		Help:      "Times a stream subscriber reopened its stream after the first connection",
+	}, []string{"stream"})
+
+	metricFailovers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: programName,
+		Name:      "nso_failovers_total",
+		Help:      "Times the subscribers moved to a new primary node of an NSO cluster",
+	}, []string{"server"})
+
+	metricSubscriberUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: programName,
+		Name:      "subscriber_up",
+		Help:      "Whether a stream subscriber is currently connected (1) or not (0)",
+	}, []string{"stream"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricEventsReceived,
+		metricHandlerErrors,
+		metricWebhookDeliveries,
+		metricWebhookLatency,
+		metricReconnects,
+		metricFailovers,
+		metricSubscriberUp,
+	)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Sinks other than HTTP have no status code, just success ("ok") or failure ("error").
+// Targets are labelled as the logs show them, without credentials
+
+func recordSinkDelivery(target string, err error, start time.Time) {
+	code := "ok"
+	if err != nil {
+		code = "error"
+	}
+	target = redactURL(target)
+	metricWebhookDeliveries.WithLabelValues(target, code).Inc()
+	metricWebhookLatency.WithLabelValues(target).Observe(time.Since(start).Seconds())
+}
+
+func recordWebhookDelivery(url string, statusCode int, start time.Time) {
+	code := "error"
+	if statusCode != 0 {
+		code = strconv.Itoa(statusCode)
+	}
+	url = redactURL(url)
+	metricWebhookDeliveries.WithLabelValues(url, code).Inc()
+	metricWebhookLatency.WithLabelValues(url).Observe(time.Since(start).Seconds())
+}
+
+// Track stream (re)connections. Any connection after a stream's first counts as a reconnect
+
+var connectedStreams sync.Map
+
+func recordSubscriberUp(stream string) {
+	if _, seen := connectedStreams.LoadOrStore(stream, true); seen {
+		metricReconnects.WithLabelValues(stream).Inc()
+	}
+	metricSubscriberUp.WithLabelValues(stream).Set(1)
+}
+
+func recordSubscriberDown(stream string) {
+	metricSubscriberUp.WithLabelValues(stream).Set(0)
+}
@@ -0,0 +1,441 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"embed"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	mockEventLogSize   = 1000
+	mockSubscriberSize = 100
+	defaultMockListen  = "127.0.0.1:8080"
+	defaultMockRate    = 5 * time.Second
+	mockKeepalive      = 30 * time.Second // an SSE comment on each stream, like NSO's keepalives
+)
+
+// A stand-in for NSO's RESTCONF API, enough for nsoevent to run against offline: the root
+// resource, ncs-state, the stream list and an SSE stream per event file. The events are
+// sent in turn, every interval, stamped with the current time, and kept so a subscription
+// with a start-time is replayed the way NSO would.
+//
+// Any credentials are accepted. A Basic login also sets a session cookie, so the session
+// auth mode can be exercised too
+
+//go:embed fixtures/*.xml
+var mockFixtures embed.FS
+
+var (
+	reMockNotification = regexp.MustCompile(`(?s)<notification[ >].*?</notification>`)
+	reMockEventTime    = regexp.MustCompile(`<eventTime>[^<]*</eventTime>`)
+)
+
+type mockEvent struct {
+	time time.Time
+	xml  []byte
+}
+
+type mockStream struct {
+	name        string
+	events      [][]byte // the events to cycle through
+	next        int
+	mu          sync.Mutex
+	log         []mockEvent // sent events, for replay
+	subscribers map[chan []byte]bool
+}
+
+type mockNSO struct {
+	URL      string
+	server   *http.Server
+	streams  []*mockStream
+	interval time.Duration
+	done     chan struct{}
+}
+
+// Events by stream name: each file holds one or more <notification> elements and is named
+// after its stream (NETCONF.xml, ncs-events.xml), or is a recording (.jsonl, .ndjson)
+// naming the stream of each event. Directories are searched for *.xml files. With no
+// paths, the built-in fixtures are used
+
+func loadMockEvents(paths []string) (map[string][][]byte, error) {
+	events := map[string][][]byte{}
+	add := func(name string, data []byte) error {
+		stream := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+		found := reMockNotification.FindAll(data, -1)
+		if len(found) == 0 {
+			return fmt.Errorf("no <notification> elements found in '%s'", name)
+		}
+		events[stream] = append(events[stream], found...)
+		return nil
+	}
+
+	if len(paths) == 0 {
+		files, _ := mockFixtures.ReadDir("fixtures")
+		for _, f := range files {
+			data, err := mockFixtures.ReadFile("fixtures/" + f.Name())
+			if err != nil {
+				return nil, err
+			}
+			if err := add(f.Name(), data); err != nil {
+				return nil, err
+			}
+		}
+		return events, nil
+	}
+
+	for _, path := range paths {
+		files := []string{path}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			files, _ = filepath.Glob(filepath.Join(path, "*.xml"))
+		}
+		for _, f := range files {
+			if isRecording(f) {
+				recorded, err := readRecording(f)
+				if err != nil {
+					return nil, err
+				}
+				for _, e := range recorded {
+					events[e.Stream] = append(events[e.Stream], []byte(e.Event))
+				}
+				continue
+			}
+			data, err := os.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			if err := add(f, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return events, nil
+}
+
+func isRecording(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".jsonl" || ext == ".ndjson"
+}
+
+// Build an unstarted mock. With an interval of 0, events are only sent by publish()
+
+func newMockNSO(events map[string][][]byte, interval time.Duration) *mockNSO {
+	m := &mockNSO{interval: interval, done: make(chan struct{})}
+	for name, list := range events {
+		m.streams = append(m.streams, &mockStream{name: name, events: list, subscribers: map[chan []byte]bool{}})
+	}
+	sort.Slice(m.streams, func(i, j int) bool { return m.streams[i].name < m.streams[j].name })
+	m.server = &http.Server{Handler: http.HandlerFunc(m.serve)}
+	return m
+}
+
+// Start listening, on a random local port if no address is given
+
+func (m *mockNSO) start(addr string, useTLS bool) error {
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	m.URL = "http://" + listener.Addr().String()
+	if useTLS {
+		certificate, err := mockCertificate()
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{certificate}})
+		m.URL = "https://" + listener.Addr().String()
+	}
+	go func() { _ = m.server.Serve(listener) }()
+
+	if m.interval > 0 {
+		for _, stream := range m.streams {
+			go m.feed(stream)
+		}
+	}
+	return nil
+}
+
+func (m *mockNSO) close() {
+	close(m.done)
+	_ = m.server.Close()
+}
+
+// A throwaway self-signed certificate for localhost
+
+func mockCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{Organization: []string{"nsoevent mock NSO"}},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+func (m *mockNSO) stream(name string) *mockStream {
+	for _, s := range m.streams {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Send the stream's events in turn, forever
+
+func (m *mockNSO) feed(stream *mockStream) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			stream.mu.Lock()
+			event := stream.events[stream.next%len(stream.events)]
+			stream.next++
+			stream.mu.Unlock()
+			_ = m.publish(stream.name, event)
+		}
+	}
+}
+
+// Send one event to everyone subscribed to a stream, stamped with the current time
+
+func (m *mockNSO) publish(name string, event []byte) error {
+	stream := m.stream(name)
+	if stream == nil {
+		return fmt.Errorf("mock NSO has no stream '%s'", name)
+	}
+
+	now := time.Now()
+	stamped := reMockEventTime.ReplaceAll(event, []byte("<eventTime>"+now.Format(time.RFC3339Nano)+"</eventTime>"))
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	stream.log = append(stream.log, mockEvent{time: now, xml: stamped})
+	if len(stream.log) > mockEventLogSize {
+		stream.log = stream.log[1:]
+	}
+	for sub := range stream.subscribers {
+		select {
+		case sub <- stamped:
+		default: // A stalled subscriber misses events, as it would with NSO
+		}
+	}
+	return nil
+}
+
+//**********
+// RESTCONF
+//**********
+
+func (m *mockNSO) serve(w http.ResponseWriter, r *http.Request) {
+	if !m.authorized(w, r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == requestURLRootResource:
+		w.Header().Set("Content-Type", "application/xrd+xml")
+		fmt.Fprint(w, `<XRD xmlns="http://docs.oasis-open.org/ns/xri/xrd-1.0"><Link rel="restconf" href="/restconf"/></XRD>`)
+	case path == "/restconf"+requestURLLogin:
+		w.Header().Set("Content-Type", "application/yang-data+xml")
+		fmt.Fprint(w, `<restconf-state xmlns="urn:ietf:params:xml:ns:yang:ietf-restconf-monitoring"/>`)
+	case path == "/restconf"+requestURLState:
+		w.Header().Set("Content-Type", "application/yang-data+xml")
+		fmt.Fprint(w, mockState)
+	case path == "/restconf"+requestURLStreamList:
+		w.Header().Set("Content-Type", "application/yang-data+xml")
+		m.serveStreamList(w, r)
+	case strings.HasPrefix(path, "/restconf/streams/") && strings.HasSuffix(path, "/xml"):
+		m.serveStream(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/restconf/streams/"), "/xml"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *mockNSO) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if _, _, ok := r.BasicAuth(); ok {
+		http.SetCookie(w, &http.Cookie{Name: "sessionid", Value: "mock", Path: "/"})
+		return true
+	}
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return true
+	}
+	_, err := r.Cookie("sessionid")
+	return err == nil
+}
+
+// Stream locations are given as localhost, like a real NSO's often are
+
+func (m *mockNSO) serveStreamList(w http.ResponseWriter, r *http.Request) {
+	_, port, _ := net.SplitHostPort(r.Host)
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	fmt.Fprint(w, `<streams xmlns="urn:ietf:params:xml:ns:yang:ietf-restconf-monitoring">`)
+	for _, s := range m.streams {
+		fmt.Fprintf(w, `<stream><name>%s</name><description>mock %s stream</description><replay-support>true</replay-support>`, s.name, s.name)
+		fmt.Fprintf(w, `<access><encoding>xml</encoding><location>%s://localhost:%s/restconf/streams/%s/xml</location></access></stream>`, scheme, port, s.name)
+	}
+	fmt.Fprint(w, `</streams>`)
+}
+
+func (m *mockNSO) serveStream(w http.ResponseWriter, r *http.Request, name string) {
+	stream := m.stream(name)
+	flusher, canFlush := w.(http.Flusher)
+	if stream == nil || !canFlush {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Subscribe before replaying so nothing falls in the gap
+
+	sub := make(chan []byte, mockSubscriberSize)
+	stream.mu.Lock()
+	stream.subscribers[sub] = true
+	var replay [][]byte
+	if start := r.URL.Query().Get("start-time"); start != "" {
+		if startTime, err := time.Parse(time.RFC3339Nano, start); err == nil {
+			for _, e := range stream.log {
+				if !e.time.Before(startTime) {
+					replay = append(replay, e.xml)
+				}
+			}
+		}
+	}
+	stream.mu.Unlock()
+	defer func() {
+		stream.mu.Lock()
+		delete(stream.subscribers, sub)
+		stream.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for _, event := range replay {
+		writeSSE(w, event)
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(mockKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-m.done:
+			return
+		case event := <-sub:
+			writeSSE(w, event)
+			flusher.Flush()
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// The mock-server command: serve until interrupted
+
+func runMockServer(addr string, eventPaths []string, interval time.Duration, useTLS bool) error {
+	events, err := loadMockEvents(eventPaths)
+	if err != nil {
+		return fmt.Errorf("(runMockServer) %v", err)
+	}
+	m := newMockNSO(events, interval)
+	if err := m.start(addr, useTLS); err != nil {
+		return fmt.Errorf("(runMockServer) %v", err)
+	}
+	defer m.close()
+
+	for _, s := range m.streams {
+		logger.Info("mock stream", "stream", s.name, "events", len(s.events))
+	}
+	logger.Info(stringColorize("### mock NSO listening", COLOR_HIGHLIGHT), "url", m.URL, "interval", interval)
+	if useTLS {
+		logger.Info("the mock uses a self-signed certificate: use nso.tls.insecure")
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+	logger.Info("mock NSO stopping")
+	return nil
+}
+
+// Every line of the event prefixed with "data: ", ending with a blank line
+
+func writeSSE(w io.Writer, event []byte) {
+	for _, line := range strings.Split(string(event), "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+const mockState = `<ncs-state xmlns="http://tail-f.com/yang/ncs-monitoring">
+  <version>mock</version>
+  <loaded-data-models>
+    <data-model>
+      <name>tailf-ncs</name>
+      <revision>2026-01-01</revision>
+      <namespace>http://tail-f.com/ns/ncs</namespace>
+      <prefix>ncs</prefix>
+    </data-model>
+  </loaded-data-models>
+  <internal>
+    <cdb>
+      <datastore>
+        <name>running</name>
+        <filename>./ncs-cdb/A.cdb</filename>
+        <disk-size>1024</disk-size>
+        <ram-size>4096</ram-size>
+      </datastore>
+    </cdb>
+  </internal>
+</ncs-state>`
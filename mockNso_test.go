@@ -0,0 +1,140 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// End to end against the mock NSO: subscribe to its NETCONF stream, publish an event, and
+// check what reaches an HTTP webhook and a file sink
+
+func TestSubscriberWithMockNSO(t *testing.T) {
+	events, err := loadMockEvents(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := os.ReadFile("testdata/decoders/NETCONF/config-change-multi-device.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newMockNSO(events, 0)
+	if err := m.start("", false); err != nil {
+		t.Fatal(err)
+	}
+	closed := false
+	defer func() {
+		if !closed {
+			m.close()
+		}
+	}()
+
+	received := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("token") != "mock-test" {
+			t.Errorf("token header %q, want mock-test", r.Header.Get("token"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+	}))
+	defer receiver.Close()
+	file := filepath.Join(t.TempDir(), "events.jsonl")
+
+	// The config processConfig would make, without it setting up logging again
+
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("nso.restconfAPI", m.URL)
+	config := &configuration{noColor: true, connectTimeout: defaultConnectTime, readTimeout: defaultReadTime}
+	if err := config.processNSOTargets(); err != nil {
+		t.Fatal(err)
+	}
+	config.streamNames = []string{"NETCONF"}
+	config.webhooks = webhooks{
+		{Stream: "NETCONF", Url: receiver.URL, Token: "mock-test", sinkType: SINK_HTTP},
+		{Stream: "NETCONF", Type: "file", File: &FileSink{Path: file, MaxSize: defaultFileMaxSize, Keep: defaultFileKeep}, sinkType: SINK_FILE},
+	}
+	liveConfig.Store(config)
+	servers, err := mainStartup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers.validateWebhooks()
+	finished := make(chan error, 1)
+	go func() { finished <- servers.startSubscribers() }()
+
+	// Publish once the subscriber is listening
+
+	stream := m.stream("NETCONF")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		stream.mu.Lock()
+		listening := len(stream.subscribers) > 0
+		stream.mu.Unlock()
+		if listening {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber never connected to the mock")
+		}
+	}
+	if err := m.publish("NETCONF", event); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload enrichData
+	select {
+	case body := <-received:
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("webhook body: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the webhook")
+	}
+	if payload.Stream != "NETCONF" || payload.EventName != "netconf-config-change" {
+		t.Errorf("stream %q event %q, want NETCONF netconf-config-change", payload.Stream, payload.EventName)
+	}
+	if want := []string{"ATX_PE_1", "ATX_RTR_Lamar", "CT_RTR_McCampbell"}; strings.Join(payload.Devices, ",") != strings.Join(want, ",") {
+		t.Errorf("devices %v, want %v", payload.Devices, want)
+	}
+	if !strings.Contains(payload.Event, "<eventTime>") || strings.Contains(payload.Event, "2026-10-18T09:15:02") {
+		t.Error("event not restamped by the mock")
+	}
+
+	// Closing the mock ends the stream, and the subscriber with it
+
+	m.close()
+	closed = true
+	select {
+	case err := <-finished:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscriber still running after the mock closed")
+	}
+
+	lines, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written enrichData
+	if err := json.Unmarshal(lines, &written); err != nil {
+		t.Fatalf("file sink: %v", err)
+	}
+	if written.Event != payload.Event {
+		t.Error("file sink and webhook payloads differ")
+	}
+}
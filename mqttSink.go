@@ -0,0 +1,282 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const mqttKeepAlive = 30 // seconds
+
+// The MQTT sink publishes through the same templates as Kafka and NATS. MQTT 3.1.1 and 5
+// need different clients; only 5 has user properties to carry the headers and key
+
+func (p *PublishSink) validateMQTT() error {
+	if p.QoS < 0 || p.QoS > 2 {
+		return fmt.Errorf("mqtt qos must be 0, 1 or 2")
+	}
+	switch p.Version {
+	case "":
+		p.Version = "3.1.1"
+	case "3.1.1", "5":
+	default:
+		return fmt.Errorf("unknown mqtt version '%s' (expected 3.1.1 or 5)", p.Version)
+	}
+	if p.RetainTopic != "" {
+		if err := checkTopicTemplate(p.RetainTopic); err != nil {
+			return fmt.Errorf("retainTopic: %v", err)
+		}
+	}
+	for i, server := range p.Servers {
+		brokerURL, err := mqttBrokerURL(server)
+		if err != nil {
+			return err
+		}
+		p.Servers[i] = brokerURL
+	}
+	return nil
+}
+
+// Brokers are tcp://host:port or ssl://host:port. mqtt:// and a bare host:port mean tcp,
+// tls:// and mqtts:// mean ssl, and the port defaults to 1883 or 8883
+
+func mqttBrokerURL(server string) (string, error) {
+	if !strings.Contains(server, "://") {
+		server = "tcp://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("invalid mqtt broker '%s'", server)
+	}
+
+	scheme, port := "tcp", "1883"
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		scheme, port = "ssl", "8883"
+	default:
+		return "", fmt.Errorf("unknown mqtt broker scheme '%s' (expected tcp, mqtt, ssl, tls or mqtts)", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return scheme + "://" + net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// Each connection needs its own client ID, or the broker drops the older one
+
+var mqttClients atomic.Int32
+
+func mqttClientID(config *PublishSink) string {
+	if config.ClientID != "" {
+		return config.ClientID
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("nsoevent-%s-%d-%d", hostname, os.Getpid(), mqttClients.Add(1))
+}
+
+func newMQTTPublisher(config *PublishSink, tlsConfig *tls.Config, timeout time.Duration) publisher {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if config.Version == "5" {
+		return &mqtt5Publisher{config: config, tlsConfig: tlsConfig, timeout: timeout, clientID: mqttClientID(config)}
+	}
+	return newMQTT3Publisher(config, tlsConfig, timeout)
+}
+
+//**********
+// MQTT 3.1.1
+//**********
+
+// The client reconnects by itself once it has connected; until then each event tries
+
+type mqtt3Publisher struct {
+	mu      sync.Mutex
+	config  *PublishSink
+	client  mqtt.Client
+	timeout time.Duration
+}
+
+func newMQTT3Publisher(config *PublishSink, tlsConfig *tls.Config, timeout time.Duration) *mqtt3Publisher {
+	options := mqtt.NewClientOptions().
+		SetClientID(mqttClientID(config)).
+		SetProtocolVersion(4).
+		SetCleanSession(true).
+		SetKeepAlive(mqttKeepAlive * time.Second).
+		SetConnectTimeout(timeout).
+		SetAutoReconnect(true).
+		SetTLSConfig(tlsConfig).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.Warn("(mqtt3Publisher) connection lost, reconnecting", "brokers", strings.Join(config.Servers, ","), "err", err)
+		})
+	for _, server := range config.Servers {
+		options.AddBroker(server)
+	}
+	if config.User != "" {
+		options.SetUsername(config.User).SetPassword(config.Password)
+	}
+	return &mqtt3Publisher{config: config, client: mqtt.NewClient(options), timeout: timeout}
+}
+
+func (p *mqtt3Publisher) connect() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client.IsConnected() {
+		return nil
+	}
+	return mqttWait(p.client.Connect(), p.timeout, "connecting")
+}
+
+func (p *mqtt3Publisher) publish(messages []publishMessage, body []byte) error {
+	if err := p.connect(); err != nil {
+		return err
+	}
+	for _, m := range messages {
+		if err := mqttWait(p.client.Publish(m.topic, byte(p.config.QoS), m.retain, body), p.timeout, "publishing"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *mqtt3Publisher) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.client.Disconnect(250) // stops any reconnecting too
+	return nil
+}
+
+func mqttWait(token mqtt.Token, timeout time.Duration, what string) error {
+	if !token.WaitTimeout(timeout) {
+		return fmt.Errorf("%s timed out after %v", what, timeout)
+	}
+	return token.Error()
+}
+
+//**********
+// MQTT 5
+//**********
+
+// A client lasts as long as its connection; the next event after it drops connects again
+
+type mqtt5Publisher struct {
+	mu        sync.Mutex
+	config    *PublishSink
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	clientID  string
+	client    *paho.Client
+}
+
+func (p *mqtt5Publisher) connect(ctx context.Context) (*paho.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client != nil {
+		select {
+		case <-p.client.Done():
+			p.client = nil
+		default:
+			return p.client, nil
+		}
+	}
+
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := paho.NewClient(paho.ClientConfig{
+		ClientID: p.clientID,
+		Conn:     conn,
+		OnClientError: func(err error) {
+			logger.Warn("(mqtt5Publisher) connection lost", "brokers", strings.Join(p.config.Servers, ","), "err", err)
+		},
+		OnServerDisconnect: func(d *paho.Disconnect) {
+			logger.Warn("(mqtt5Publisher) disconnected by the broker", "brokers", strings.Join(p.config.Servers, ","), "reason", d.ReasonCode)
+		},
+	})
+	connect := &paho.Connect{ClientID: p.clientID, KeepAlive: mqttKeepAlive, CleanStart: true}
+	if p.config.User != "" {
+		connect.Username, connect.UsernameFlag = p.config.User, true
+		connect.Password, connect.PasswordFlag = []byte(p.config.Password), true
+	}
+	if _, err := client.Connect(ctx, connect); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	p.client = client
+	return client, nil
+}
+
+// The first broker that answers
+
+func (p *mqtt5Publisher) dial(ctx context.Context) (net.Conn, error) {
+	var err error
+	for _, server := range p.config.Servers {
+		scheme, address, _ := strings.Cut(server, "://")
+		var conn net.Conn
+		if scheme == "ssl" {
+			dialer := &tls.Dialer{Config: p.tlsConfig}
+			conn, err = dialer.DialContext(ctx, "tcp", address)
+		} else {
+			dialer := &net.Dialer{}
+			conn, err = dialer.DialContext(ctx, "tcp", address)
+		}
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *mqtt5Publisher) publish(messages []publishMessage, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	client, err := p.connect(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		properties := &paho.PublishProperties{ContentType: "application/json"}
+		if m.key != "" {
+			properties.User.Add("Key", m.key)
+		}
+		for _, h := range m.headers {
+			if h[1] != "" {
+				properties.User.Add(h[0], h[1])
+			}
+		}
+		publish := &paho.Publish{Topic: m.topic, QoS: byte(p.config.QoS), Retain: m.retain, Payload: body, Properties: properties}
+		if _, err := client.Publish(ctx, publish); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *mqtt5Publisher) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == nil {
+		return nil
+	}
+	err := p.client.Disconnect(&paho.Disconnect{ReasonCode: 0})
+	p.client = nil
+	return err
+}
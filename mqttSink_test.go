@@ -0,0 +1,162 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// The MQTT sink against an embedded broker, in both protocol versions: the event on its
+// topic, and a retained copy per device for subscribers that arrive later
+
+func newMQTTBroker(t *testing.T) (*mochi.Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	_ = listener.Close()
+
+	broker := mochi.New(&mochi.Options{InlineClient: true, Logger: logger})
+	if err := broker.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.AddListener(listeners.NewTCP(listeners.Config{ID: "test", Address: address})); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	return broker, address
+}
+
+// Packets delivered to an inline subscription
+
+type mqttReceived struct {
+	mu      sync.Mutex
+	packets []packets.Packet
+}
+
+func (r *mqttReceived) handler(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.packets = append(r.packets, pk)
+}
+
+func (r *mqttReceived) wait(t *testing.T, count int) []packets.Packet {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		r.mu.Lock()
+		if len(r.packets) >= count {
+			received := append([]packets.Packet(nil), r.packets...)
+			r.mu.Unlock()
+			return received
+		}
+		r.mu.Unlock()
+	}
+	t.Fatalf("timed out waiting for %d MQTT messages", count)
+	return nil
+}
+
+func mqttUserProperty(pk packets.Packet, key string) string {
+	for _, p := range pk.Properties.User {
+		if p.Key == key {
+			return p.Val
+		}
+	}
+	return ""
+}
+
+func TestMQTTSink(t *testing.T) {
+	broker, address := newMQTTBroker(t)
+	defer broker.Close()
+
+	for i, version := range []string{"3.1.1", "5"} {
+		t.Run(version, func(t *testing.T) {
+			events := new(mqttReceived)
+			if err := broker.Subscribe("v"+version+"/NETCONF/#", i*2+1, events.handler); err != nil {
+				t.Fatal(err)
+			}
+
+			hook := publishTestHook(t, "mqtt", &PublishSink{
+				Servers:     []string{address},
+				Topic:       "v" + version + "/{stream}/{eventname}",
+				QoS:         1,
+				RetainTopic: "v" + version + "/devices/{device}/last",
+				Version:     version,
+			})
+			sub, body := publishTestEvent(t)
+			if err := hook.sink.send(sub, body); err != nil {
+				t.Fatal(err)
+			}
+
+			pk := events.wait(t, 1)[0]
+			if want := "v" + version + "/NETCONF/netconf-config-change"; pk.TopicName != want {
+				t.Errorf("topic %s, want %s", pk.TopicName, want)
+			}
+			if pk.FixedHeader.Retain {
+				t.Error("event message retained")
+			}
+			if string(pk.Payload) != string(body) {
+				t.Error("payload differs")
+			}
+			if version == "5" {
+				if got := mqttUserProperty(pk, "EventTime"); got != publishEventTime {
+					t.Errorf("EventTime property %q, want %q", got, publishEventTime)
+				}
+				if got := mqttUserProperty(pk, "Key"); got != "ATX_PE_1,ATX_RTR_Lamar,CT_RTR_McCampbell" {
+					t.Errorf("Key property %q, want the devices", got)
+				}
+			}
+
+			// A late subscriber gets each device's last event
+
+			last := new(mqttReceived)
+			if err := broker.Subscribe("v"+version+"/devices/+/last", i*2+2, last.handler); err != nil {
+				t.Fatal(err)
+			}
+			var topics []string
+			for _, pk := range last.wait(t, len(publishDevices)) {
+				if !pk.FixedHeader.Retain {
+					t.Errorf("%s not retained", pk.TopicName)
+				}
+				topics = append(topics, pk.TopicName)
+			}
+			sort.Strings(topics)
+			for d, device := range publishDevices {
+				if want := "v" + version + "/devices/" + device + "/last"; topics[d] != want {
+					t.Errorf("retained topic %s, want %s", topics[d], want)
+				}
+			}
+		})
+	}
+}
+
+func TestMQTTBrokerURL(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"broker", "tcp://broker:1883"},
+		{"broker:1884", "tcp://broker:1884"},
+		{"mqtt://broker", "tcp://broker:1883"},
+		{"mqtts://broker", "ssl://broker:8883"},
+		{"tls://broker:9883", "ssl://broker:9883"},
+	}
+	for _, test := range tests {
+		if got, err := mqttBrokerURL(test.in); err != nil || got != test.want {
+			t.Errorf("mqttBrokerURL(%q) = %q, %v, want %q", test.in, got, err, test.want)
+		}
+	}
+	if _, err := mqttBrokerURL("ws://broker"); err == nil {
+		t.Error("mqttBrokerURL accepted ws://")
+	}
+}
@@ -46,6 +46,7 @@ func (e EventType) String() string {
 type Notification struct {
 	XMLName     xml.Name                              `xml:"notification" json:"-"`
 	EventTime   time.Time                             `xml:"eventTime" json:"eventTime"`
+	Server      string                                `xml:"-"`
 	EventName   string                                `xml:"-"`
 	EventType   EventType                             `xml:"-"`
 	User        string                                `xml:"-"`
@@ -55,6 +56,7 @@ type Notification struct {
 	Edits       []*NetconfConfigChangeEdit            `xml:"-"`
 	DeviceEdits map[string][]*NetconfConfigChangeEdit `xml:"-"`
 	Inner       []byte                                `xml:",innerxml"`
+	Raw         []byte                                `xml:"-" json:"-"` // the element as received, when recording
 }
 
 // The initial sizing in the new Notification is somewhat arbitrary
@@ -158,8 +160,8 @@ func (n *Notification) handlerNcsEvents(sub streamSubscriber) (string, error) {
 
 	// TODO: Do there really need to be 2 Notification structures?
 
-	// debugMsgf("[%s] (Notification:handlerNcsEvents) inner:\n%s\n",
-	//  	stringColorize(sub.stream.Name, COLOR_STREAM), xmlInnerCleanup(n.Inner))
+	// logger.Debug("(Notification:handlerNcsEvents) inner",
+	//  	"stream", sub.stream.Name, "inner", string(xmlInnerCleanup(n.Inner)))
 
 	rootInner := append(append([]byte("<root>"), n.Inner[:]...), []byte("</root>")...)
 	err := xml.Unmarshal(rootInner, &nInner)
@@ -222,8 +224,8 @@ var reDevice = regexp.MustCompile("ncs:device\\[ncs:name='([^']+)'\\]")
 func (n *Notification) handlerNetconf(sub streamSubscriber) (string, error) {
 	nInner := new(NotificationInner)
 
-	// debugMsgf("[%s] (Notification:handlerNetconf) inner:\n%s\n",
-	//      stringColorize(sub.stream.Name, COLOR_STREAM), xmlInnerCleanup(n.Inner))
+	// logger.Debug("(Notification:handlerNetconf) inner",
+	//      "stream", sub.stream.Name, "inner", string(xmlInnerCleanup(n.Inner)))
 
 	// Fake a root node so unmarshal is happy. I guess the notification could all be decoded
 	// before this point into the outer Notification structure, but does it make sense to
@@ -277,7 +279,7 @@ func (n *Notification) handlerNetconf(sub streamSubscriber) (string, error) {
 
 		if subMatches := reDevice.FindStringSubmatch(edit.Target); subMatches != nil {
 			devName := subMatches[1]
-			if index := sort.SearchStrings(n.Devices, devName); index == len(n.Devices) {
+			if index := sort.SearchStrings(n.Devices, devName); index == len(n.Devices) || n.Devices[index] != devName {
 				n.Devices = append(n.Devices, devName)
 				sort.Strings(n.Devices)
 			}
@@ -308,6 +310,7 @@ func xmlInnerCleanup(source []byte) []byte {
 
 type enrichData struct {
 	Source    string                                `json:"source"`
+	Server    string                                `json:"server"`
 	Stream    string                                `json:"stream"`
 	EventName string                                `json:"eventname"`
 	User      string                                `json:"user,omitempty"`
@@ -335,6 +338,7 @@ func (n *Notification) enrichData(sub streamSubscriber, source []byte) []byte {
 
 	body := &enrichData{
 		Source:    sub.url.Host,
+		Server:    n.Server,
 		Stream:    sub.stream.Name,
 		EventName: n.EventName,
 		User:      n.User,
@@ -350,8 +354,7 @@ func (n *Notification) enrichData(sub streamSubscriber, source []byte) []byte {
 		panic(fmt.Errorf("(Notification:enrichData) jsonMarshal"))
 	}
 
-	debugMsgf("[%s] (Notification:enrichData) result '%s'\n",
-		stringColorize(sub.stream.Name, COLOR_STREAM), result)
+	logger.Debug("(Notification:enrichData) result", "stream", sub.stream.label(), "body", string(result))
 
 	return result
 }
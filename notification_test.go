@@ -0,0 +1,183 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Golden-file tests for the notification decoders. Each testdata/decoders/STREAM/NAME.xml
+// is a notification as NSO sends it, decoded the way the subscriber does (from an SSE
+// stream, by the stream's handler) into NAME.golden.json. After a deliberate change to the
+// decoding, rewrite the golden files with
+//
+//	go test -run TestDecoders -update
+//
+// and review the diff
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestMain(m *testing.M) {
+	Config().noColor = true
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	os.Exit(m.Run())
+}
+
+// Everything the decoders produce for one notification
+
+type decoderResult struct {
+	Error       string                                `json:"error,omitempty"`
+	Message     string                                `json:"message,omitempty"`
+	EventTime   string                                `json:"eventTime,omitempty"`
+	EventName   string                                `json:"eventName,omitempty"`
+	EventType   string                                `json:"eventType,omitempty"`
+	User        string                                `json:"user,omitempty"`
+	UserHost    string                                `json:"userHost,omitempty"`
+	Datastore   string                                `json:"datastore,omitempty"`
+	Devices     []string                              `json:"devices,omitempty"`
+	Edits       []*NetconfConfigChangeEdit            `json:"edits,omitempty"`
+	DeviceEdits map[string][]*NetconfConfigChangeEdit `json:"deviceEdits,omitempty"`
+	Body        json.RawMessage                       `json:"body,omitempty"`
+}
+
+func decodeEvent(stream string, event []byte) decoderResult {
+	var sse bytes.Buffer
+	writeSSE(&sse, event)
+
+	n := newNotification()
+	if err := xml.NewDecoder(&sse).Decode(n); err != nil {
+		return decoderResult{Error: "decode: " + err.Error()}
+	}
+
+	sub := &streamSubscriber{
+		server:  &NsoServer{name: "nso1"},
+		stream:  &Stream{Name: stream, server: "nso1"},
+		url:     &url.URL{Scheme: "http", Host: "nso1.example.com:8080"},
+		handler: (*Notification).handlerDefault,
+	}
+	subscriberList{sub}.registerHandlers()
+	n.Server = sub.server.name
+
+	msg, err := sub.handler(n, *sub)
+	if err != nil {
+		return decoderResult{Error: "handler: " + err.Error()}
+	}
+	return decoderResult{
+		Message:     msg,
+		EventTime:   n.EventTime.Format("2006-01-02T15:04:05.999999999Z07:00"),
+		EventName:   n.EventName,
+		EventType:   n.EventType.String(),
+		User:        n.User,
+		UserHost:    n.UserHost,
+		Datastore:   n.Datastore,
+		Devices:     n.Devices,
+		Edits:       n.Edits,
+		DeviceEdits: n.DeviceEdits,
+		Body:        n.enrichData(*sub, xmlInnerCleanup(n.Inner)),
+	}
+}
+
+func TestDecoders(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "decoders", "*", "*.xml"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("no decoder fixtures found: %v", err)
+	}
+
+	for _, fixture := range fixtures {
+		stream := filepath.Base(filepath.Dir(fixture))
+		name := strings.TrimSuffix(filepath.Base(fixture), ".xml")
+		t.Run(stream+"/"+name, func(t *testing.T) {
+			event, err := os.ReadFile(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buffer bytes.Buffer
+			encoder := json.NewEncoder(&buffer)
+			encoder.SetEscapeHTML(false)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(decodeEvent(stream, event)); err != nil {
+				t.Fatal(err)
+			}
+			got := buffer.Bytes()
+
+			golden := strings.TrimSuffix(fixture, ".xml") + ".golden.json"
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("decoded %s differs from %s:\n--- got\n%s\n--- want\n%s", fixture, golden, got, want)
+			}
+		})
+	}
+}
+
+func TestXMLInnerCleanup(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", "<a>1</a>", "<a>1</a>"},
+		{"sse lines", "\ndata: <a>1</a>\ndata: <b>2</b>", "<a>1</a><b>2</b>"},
+		{"sse indented", "\ndata:     <a>1</a>", "<a>1</a>"},
+		{"no newline", "data: <a>1</a>", "data: <a>1</a>"},
+		{"value containing data:", "<a>data: x</a>", "<a>data: x</a>"},
+		{"empty", "", ""},
+	}
+	for _, test := range tests {
+		if got := string(xmlInnerCleanup([]byte(test.in))); got != test.want {
+			t.Errorf("%s: xmlInnerCleanup(%q) = %q, want %q", test.name, test.in, got, test.want)
+		}
+	}
+}
+
+// Whatever arrives on a stream, decoding must not panic and the webhook body must be JSON
+
+func FuzzDecoders(f *testing.F) {
+	for _, pattern := range []string{"testdata/decoders/*/*.xml", "fixtures/*.xml"} {
+		files, _ := filepath.Glob(pattern)
+		for _, file := range files {
+			if data, err := os.ReadFile(file); err == nil {
+				f.Add(data)
+			}
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, event []byte) {
+		for _, stream := range []string{"NETCONF", "ncs-events", "other"} {
+			result := decodeEvent(stream, event)
+			if result.Body != nil && !json.Valid(result.Body) {
+				t.Errorf("%s: invalid JSON body %q", stream, result.Body)
+			}
+		}
+	})
+}
+
+func FuzzXMLInnerCleanup(f *testing.F) {
+	f.Add([]byte("\ndata: <a>1</a>\ndata:     <b>2</b>"))
+	f.Add([]byte("<a>data: x</a>"))
+
+	f.Fuzz(func(t *testing.T, inner []byte) {
+		if out := xmlInnerCleanup(inner); len(out) > len(inner) {
+			t.Errorf("xmlInnerCleanup grew %q to %q", inner, out)
+		}
+	})
+}
@@ -7,7 +7,6 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/xml"
 	"errors"
 	"fmt"
@@ -17,6 +16,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 const (
@@ -37,6 +37,9 @@ func (t ResponseContentType) String() string {
 }
 
 type NsoServer struct {
+	name         string
+	target       *nsoInfo
+	node         nsoNode // the node in use, the primary of a cluster
 	apiUrl       string
 	apiPort      int
 	user         string
@@ -45,15 +48,91 @@ type NsoServer struct {
 	Version      string
 	State        *State
 	StreamList   *StreamList
+	client       *http.Client
+	auth         nsoAuth
+	ha           *haState // clusters only
+
+	// A failover rewrites node, apiUrl, apiPort, RootResource and StreamList while other
+	// subscribers and the health endpoints are using them, so outside of startup they're
+	// read through the accessors below
+
+	connLock sync.RWMutex
+}
+
+func newNSOServer(target *nsoInfo) *NsoServer {
+	s := &NsoServer{
+		name:     target.name,
+		target:   target,
+		user:     target.user,
+		password: target.password,
+		client:   newHTTPClient(target.tls, target.connectTimeout),
+	}
+	s.auth = s.newAuth(target.auth)
+	s.useNode(target.nodes[0])
+	if s.clustered() {
+		s.ha = &haState{changed: make(chan struct{})}
+	}
+	return s
+}
+
+func (s *NsoServer) useNode(node nsoNode) {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	s.node = node
+	s.apiUrl = node.apiUrl
+	s.apiPort = node.port
+}
+
+func (s *NsoServer) currentNode() nsoNode {
+	s.connLock.RLock()
+	defer s.connLock.RUnlock()
+	return s.node
+}
+
+// The node's API URL and RESTCONF root resource, read together so they always belong to
+// the same node. The root is empty until it has been discovered
+
+func (s *NsoServer) connection() (string, string) {
+	s.connLock.RLock()
+	defer s.connLock.RUnlock()
+	return s.apiUrl, s.RootResource
+}
+
+func (s *NsoServer) setRootResource(root string) {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	s.RootResource = root
 }
 
-func newNSOServer() *NsoServer {
-	return &NsoServer{
-		apiUrl:   Config.nsoTarget.apiUrl,
-		apiPort:  Config.nsoTarget.port,
-		user:     Config.nsoTarget.user,
-		password: Config.nsoTarget.password,
+func (s *NsoServer) streams() *StreamList {
+	s.connLock.RLock()
+	defer s.connLock.RUnlock()
+	return s.StreamList
+}
+
+type nsoServers []*NsoServer
+
+// Run a command against each server, with a heading per server when there are several
+
+func (servers nsoServers) each(f func(*NsoServer) error) error {
+	for _, s := range servers {
+		if len(servers) > 1 {
+			fmt.Printf("%s %s (%s)\n", stringColorize("### NSO server", COLOR_HEADINGS), stringColorize(s.name, COLOR_HI_YELLOW), s.apiUrl)
+		}
+		if err := f(s); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+func (servers nsoServers) find(name string) *NsoServer {
+	for _, s := range servers {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
 }
 
 type Link struct {
@@ -72,9 +151,6 @@ type Xrd struct {
 
 func (s *NsoServer) getRootResource() error {
 
-	// A BAD idea, but for now...
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-
 	data, contentType, err := s.getResourceData(requestURLRootResource)
 	if err != nil {
 		return err
@@ -87,14 +163,14 @@ func (s *NsoServer) getRootResource() error {
 	xrd := new(Xrd)
 	err = xml.Unmarshal(data, &xrd)
 	if err != nil {
-		fmt.Printf("(NSOServer:getRootResource) xml.Unmarshal: %v", err)
+		logger.Error("(NSOServer:getRootResource) xml.Unmarshal", "err", err)
 		return err
 	}
 
 	if href := xrd.findHref("restconf"); href == "" {
 		return errors.New("(NSOServer:getRootResource) unable to determine HREF for 'restconf'")
 	} else {
-		s.RootResource = href
+		s.setRootResource(href)
 	}
 
 	return nil
@@ -108,25 +184,27 @@ func (s *NsoServer) getStreamList() error {
 		return err
 	}
 
-	streamList, err := newStreamList(data)
+	streamList, err := newStreamList(data, s.name, s.currentNode())
 	if err != nil {
-		fmt.Printf("(getStreamList) newStreamList: %v", err)
+		logger.Error("(getStreamList) newStreamList", "err", err)
 		return err
 	}
 
+	s.connLock.Lock()
 	s.StreamList = streamList
+	s.connLock.Unlock()
 	return nil
 }
 
 func (s *NsoServer) findStreamsByName(targetName string) []*Stream {
-	return s.StreamList.findStreamsByName(targetName)
+	return s.streams().findStreamsByName(targetName)
 }
 
 // Get the raw data associated with a particular resource URL
 
 func (s *NsoServer) getResourceData(r string) ([]byte, ResponseContentType, error) {
-	resourceUrl := s.RootResource + r
-	reqUrl, err := url.Parse(s.apiUrl + resourceUrl)
+	apiUrl, root := s.connection()
+	reqUrl, err := url.Parse(apiUrl + root + r)
 	if err != nil {
 		return nil, ResponseContentUnknown, err
 	}
@@ -140,35 +218,28 @@ func (s *NsoServer) getResourceData(r string) ([]byte, ResponseContentType, erro
 		Body: nil,
 	}
 
-	// Basic authentication header
-	req.SetBasicAuth(s.user, s.password)
-
 	// Issue the request with specified timeout
-	ctx, cancel := context.WithTimeout(context.Background(), Config.readTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), s.target.readTimeout)
 	defer cancel()
 
-	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	resp, err := s.do(req.WithContext(ctx))
 	if err != nil {
 		if e, ok := err.(net.Error); ok && e.Timeout() {
-			fmt.Println("(NSOServer:getResourceData) " + stringColorize("URL timeout", COLOR_ERROR))
+			logger.Error("(NSOServer:getResourceData) URL timeout", "url", reqUrl.String())
 		}
 		return nil, ResponseContentUnknown, err
 	}
 
-	debugMsgf("(NSOServer:getResourceData) HTTP %s response for %s\n",
-		stringColorize(strconv.Itoa(resp.StatusCode), COLOR_HI_BLUE),
-		stringColorize(req.URL.String(), COLOR_URL))
+	logger.Debug("(NSOServer:getResourceData) response", "status", resp.StatusCode, "url", req.URL.String())
 
 	data, _ := io.ReadAll(resp.Body)
 	_ = resp.Body.Close()
 
 	contentType := determineResponseType(resp.Header["Content-Type"])
 
-	if Config.verbose {
-		//fmt.Printf("(NSOServer:getResourceData) response headers [%s]:\n%q\n", resourceUrl, resp.Header)
-		fmt.Printf("(NSOServer:getResourceData) response headers [%s]:\n%q\n", reqUrl, resp.Header)
-		fmt.Printf("(NSOServer:getResourceData) %s response body:\n%s\n",
-			stringColorize(contentType.String(), COLOR_HI_BLUE), string(data))
+	if traceEnabled() {
+		logTrace("(NSOServer:getResourceData) response headers", "url", reqUrl.String(), "headers", fmt.Sprintf("%q", resp.Header))
+		logTrace("(NSOServer:getResourceData) response body", "contentType", contentType.String(), "body", string(data))
 	}
 
 	return data, contentType, nil
@@ -188,10 +259,7 @@ func (s *NsoServer) openStream(reqUrl *url.URL) (io.ReadCloser, error) {
 		Body: nil,
 	}
 
-	// Basic authentication header
-	req.SetBasicAuth(s.user, s.password)
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := s.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -199,10 +267,10 @@ func (s *NsoServer) openStream(reqUrl *url.URL) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
-// Validate list of webhooks against the server's list of available streams
+// Validate list of webhooks against the servers' lists of available streams
 
-func (s *NsoServer) validateWebhooks() {
-	Config.webhooks.validate(s.StreamList)
+func (servers nsoServers) validateWebhooks() {
+	_ = Config().webhooks.validate(servers)
 }
 
 // Find an href using a rel
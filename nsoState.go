@@ -130,7 +130,7 @@ func (s *NsoServer) getState() error {
 	state := new(State)
 	err = xml.Unmarshal(data, &state)
 	if err != nil {
-		fmt.Printf("(getState) xml.Unmarshal: %v", err)
+		logger.Error("(getState) xml.Unmarshal", "err", err)
 		return err
 	}
 
@@ -215,12 +215,12 @@ func (l LoadedDataModels) print() {
 		return
 	}
 
-	if Config.showMounts {
+	if Config().showMounts {
 		// Determine which data models the mounts are referencing
 		for _, mount := range l.MountList {
 			for _, mountModel := range mount.DataModelList {
 				if foundModel := l.findByName(mountModel.Name); foundModel != nil {
-					debugMsgf("(LoadedDataModels:print) adding mount %s to %s\n", mount.MountId, foundModel.Name)
+					logger.Debug("(LoadedDataModels:print) adding mount", "mount", mount.MountId, "model", foundModel.Name)
 					foundModel.mountList = append(foundModel.mountList, mount)
 				}
 			}
@@ -264,7 +264,7 @@ func (l LoadedDataModels) print() {
 				stringColorize(item.Namespace, modelColor),
 			}
 			extra := ""
-			if Config.showMounts {
+			if Config().showMounts {
 				for _, mount := range item.mountList {
 					extra = extra + fmt.Sprintf(stringColorize(fmt.Sprintf("  --> mount: %s\n", mount.MountId), COLOR_HI_WHITE))
 				}
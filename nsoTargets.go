@@ -0,0 +1,249 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var restconfApiRE = regexp.MustCompile("http(s?)://([0-9A-Za-z.-]*):?([0-9]*)?")
+
+// The NSO servers to talk to. 'nso' is either a single server (a map, as it always was,
+// with the --url/--user/--password flags applying to it) or a list of named servers:
+//
+//   nso:
+//     - name: lab
+//       restconfAPI: http://10.1.1.1:8080
+//     - name: production
+//       restconfAPI: https://nso.example.com:8888
+//       auth: session
+//
+// List entries take the same settings as the single server. Every notification and webhook
+// payload is tagged with the name of the server it came from.
+//
+// A server running as an HA cluster lists the URL of each node under 'nodes' (see ha.go)
+
+type nsoInfo struct {
+	name           string
+	nodes          []nsoNode // the first is the default, or the only node
+	haInterval     time.Duration
+	user           string
+	password       string
+	tls            *tls.Config
+	auth           AuthMode
+	token          string
+	tokenRef       string // unresolved, to fetch the token again on a 401
+	connectTimeout time.Duration
+	readTimeout    time.Duration
+}
+
+type nsoNode struct {
+	cmdUrl    string
+	apiUrl    string
+	ipAddress string
+	port      int
+}
+
+type nsoConfig struct {
+	Name            string
+	RestconfAPI     string
+	Nodes           []string
+	HACheckInterval time.Duration
+	User            string
+	Password        string
+	Auth            string
+	Token           string
+	ConnectTimeout  time.Duration
+	ReadTimeout     time.Duration
+	TLS             TLSConfig
+}
+
+func (config *configuration) processNSOTargets() error {
+	var configs []nsoConfig
+
+	if _, isList := viper.Get("nso").([]interface{}); isList {
+		if err := viper.UnmarshalKey("nso", &configs); err != nil {
+			return fmt.Errorf("fatal error processing config file for 'nso' key: %v", err)
+		}
+
+		// The nso.* defaults don't apply to a list, so the servers fall back to these
+		if config.connectTimeout == 0 {
+			config.connectTimeout = defaultConnectTime
+		}
+		if config.readTimeout == 0 {
+			config.readTimeout = defaultReadTime
+		}
+		for _, flag := range []string{"nso.restconfapi", "nso.user", "nso.password", "nso.auth", "nso.readtimeout"} {
+			if f := flagBindings[flag]; f != nil && f.Changed {
+				logger.Warn("flag ignored, the config file defines a list of NSO servers", "flag", "--"+f.Name)
+			}
+		}
+	} else {
+		single := nsoConfig{
+			Name:            viper.GetString("nso.name"),
+			RestconfAPI:     viper.GetString("nso.restconfAPI"),
+			User:            viper.GetString("nso.user"),
+			Password:        viper.GetString("nso.password"),
+			Auth:            viper.GetString("nso.auth"),
+			Token:           viper.GetString("nso.token"),
+			Nodes:           viper.GetStringSlice("nso.nodes"),
+			HACheckInterval: viper.GetDuration("nso.haCheckInterval"),
+		}
+		if err := viper.UnmarshalKey("nso.tls", &single.TLS); err != nil {
+			return fmt.Errorf("fatal error processing config file for 'nso.tls' key: %v", err)
+		}
+		configs = append(configs, single)
+	}
+
+	if len(configs) == 0 {
+		return fmt.Errorf("no NSO servers defined")
+	}
+
+	targets := make([]*nsoInfo, 0, len(configs))
+	names := map[string]bool{}
+	for i, c := range configs {
+		if len(configs) > 1 && c.Name == "" {
+			return fmt.Errorf("NSO server %d has no name (required when there are several)", i+1)
+		}
+		target, err := newNSOTarget(c, config)
+		if err != nil {
+			if c.Name != "" {
+				return fmt.Errorf("NSO server '%s': %v", c.Name, err)
+			}
+			return err
+		}
+		if names[target.name] {
+			return fmt.Errorf("NSO server name '%s' used more than once", target.name)
+		}
+		names[target.name] = true
+		targets = append(targets, target)
+	}
+
+	config.nsoTargets = targets
+	return nil
+}
+
+func newNSOTarget(c nsoConfig, config *configuration) (*nsoInfo, error) {
+	target := &nsoInfo{
+		name:           c.Name,
+		haInterval:     c.HACheckInterval,
+		user:           c.User,
+		tokenRef:       c.Token,
+		connectTimeout: c.ConnectTimeout,
+		readTimeout:    c.ReadTimeout,
+	}
+	if target.user == "" {
+		target.user = defaultNSOUser
+	}
+	if c.Password == "" {
+		c.Password = defaultNSOPassword
+	}
+	if target.connectTimeout == 0 {
+		target.connectTimeout = config.connectTimeout
+	}
+	if target.readTimeout == 0 {
+		target.readTimeout = config.readTimeout
+	}
+
+	var err error
+	if target.password, err = resolveSecret(c.Password); err != nil {
+		return nil, fmt.Errorf("nso.password: %v", err)
+	}
+
+	if target.auth, err = parseAuthMode(c.Auth); err != nil {
+		return nil, err
+	}
+	if target.token, err = resolveSecret(target.tokenRef); err != nil {
+		return nil, fmt.Errorf("nso.token: %v", err)
+	}
+	if target.auth == AUTH_BEARER && target.token == "" {
+		return nil, fmt.Errorf("nso.auth bearer requires nso.token")
+	}
+
+	// Parse the NSO URL(s). For a cluster, restconfAPI (if given) is just another node
+	urls := c.Nodes
+	if c.RestconfAPI != "" && len(urls) == 0 {
+		urls = []string{c.RestconfAPI}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("NSO API URL not found")
+	}
+	for _, u := range urls {
+		node, err := parseNSONode(u)
+		if err != nil {
+			return nil, err
+		}
+		target.nodes = append(target.nodes, node)
+	}
+	if target.haInterval == 0 {
+		target.haInterval = defaultHACheckInterval
+	}
+
+	// TLS? Certificates are verified unless nso.tls.insecure is set
+	if target.tls, err = c.TLS.clientConfig(); err != nil {
+		return nil, fmt.Errorf("NSO %v", err)
+	}
+	if c.TLS.Insecure && strings.HasPrefix(target.nodes[0].apiUrl, "https") {
+		logger.Warn("NSO server certificate verification disabled (nso.tls.insecure)", "url", target.nodes[0].cmdUrl)
+	}
+
+	// A single unnamed server is known by its address
+	if target.name == "" {
+		target.name = target.nodes[0].ipAddress
+	}
+	return target, nil
+}
+
+func parseNSONode(cmdUrl string) (nsoNode, error) {
+	node := nsoNode{cmdUrl: cmdUrl}
+
+	subMatches := restconfApiRE.FindStringSubmatch(cmdUrl)
+	if len(subMatches) < 3 {
+		return node, fmt.Errorf("cannot parse NSO API URL '%s'", cmdUrl)
+	}
+
+	protocol := "http"
+	if subMatches[1] != "" {
+		protocol = "https"
+	}
+
+	node.ipAddress = defaultNSOAddress
+	if subMatches[2] != "" {
+		node.ipAddress = subMatches[2]
+	}
+
+	node.port = defaultNSOPort
+	if len(subMatches) == 4 && subMatches[3] != "" {
+		port, err := strconv.Atoi(subMatches[3])
+		if err != nil {
+			return node, fmt.Errorf("invalid NSO API port in '%s'", subMatches[0])
+		}
+		node.port = port
+	}
+
+	node.apiUrl = fmt.Sprintf("%s://%s:%d", protocol, node.ipAddress, node.port)
+	return node, nil
+}
+
+func multipleNSOTargets() bool {
+	return len(Config().nsoTargets) > 1
+}
+
+func (config *configuration) findNSOTarget(name string) *nsoInfo {
+	for _, t := range config.nsoTargets {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,415 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+const (
+	defaultPublishTopic   = "nso.{stream}.{eventname}"
+	defaultMQTTTopic      = "nso/{stream}/{eventname}"
+	defaultPublishTimeout = 10 * time.Second
+	publishNoDevice       = "none" // {device} for events that don't name one, as in the edits
+)
+
+// Settings for the message bus sinks, which publish the payload to a topic (Kafka, MQTT) or
+// subject (NATS) built from a template. The message key is the event's devices, and the
+// headers (MQTT 5 user properties) carry the event time, stream, event name and server
+
+type PublishSink struct {
+	Servers     []string // Kafka brokers as host:port, NATS server URLs, or MQTT broker URLs
+	Topic       string   // template, default nso.{stream}.{eventname} (nso/{stream}/{eventname} for MQTT)
+	User        string   // SASL/PLAIN for Kafka, user and password for NATS and MQTT
+	Password    string
+	Acks        string // Kafka acknowledgements: all (default), one or none
+	QoS         int    // MQTT quality of service, 0 (default), 1 or 2
+	Retain      bool   // MQTT: the broker keeps the last message on each topic
+	RetainTopic string // MQTT: also publish a retained copy here, e.g. nso/devices/{device}/last
+	Version     string // MQTT protocol: 3.1.1 (default) or 5
+	ClientID    string // MQTT, default nsoevent-HOST-PID-N
+}
+
+var kafkaAcks = map[string]kafka.RequiredAcks{
+	"all":  kafka.RequireAll,
+	"one":  kafka.RequireOne,
+	"none": kafka.RequireNone,
+}
+
+// Fill in the defaults and check the topic template, for processConfig
+
+func (p *PublishSink) validate(sinkType SinkType) error {
+	if len(p.Servers) == 0 {
+		return fmt.Errorf("missing %s servers", sinkType)
+	}
+	if p.Topic == "" {
+		p.Topic = defaultPublishTopic
+		if sinkType == SINK_MQTT {
+			p.Topic = defaultMQTTTopic
+		}
+	}
+	if err := checkTopicTemplate(p.Topic); err != nil {
+		return err
+	}
+	if sinkType == SINK_MQTT {
+		return p.validateMQTT()
+	}
+	if p.QoS != 0 || p.Retain || p.RetainTopic != "" || p.Version != "" || p.ClientID != "" {
+		return fmt.Errorf("qos, retain, retainTopic, version and clientId are for mqtt only")
+	}
+	if sinkType == SINK_KAFKA {
+		if p.Acks == "" {
+			p.Acks = "all"
+		}
+		if _, found := kafkaAcks[strings.ToLower(p.Acks)]; !found {
+			return fmt.Errorf("unknown kafka acks '%s' (expected all, one or none)", p.Acks)
+		}
+	}
+	return nil
+}
+
+// The publishing sinks' time limit for each event, including connecting
+
+func (hook *webhook) publishTimeout() time.Duration {
+	if hook.Timeout > 0 {
+		return hook.Timeout
+	}
+	return defaultPublishTimeout
+}
+
+func (hook *webhook) publishTLS() (*tls.Config, error) {
+	if hook.TLS == nil {
+		return nil, nil
+	}
+	return hook.TLS.clientConfig()
+}
+
+//**********
+// Topics
+//**********
+
+// A topic template names payload fields in braces: {stream}, {eventname}, {server},
+// {source}, {user}, {host}, {datastore} or {device}. With {device} the event is published
+// once for each of its devices, keyed by that device
+
+var topicFields = regexp.MustCompile(`\{([^{}]*)\}`)
+
+var topicFieldNames = map[string]bool{
+	"stream": true, "eventname": true, "server": true, "source": true,
+	"user": true, "host": true, "datastore": true, "device": true,
+}
+
+func checkTopicTemplate(template string) error {
+	for _, match := range topicFields.FindAllStringSubmatch(template, -1) {
+		if !topicFieldNames[match[1]] {
+			return fmt.Errorf("unknown topic template field '%s'", match[0])
+		}
+	}
+	return nil
+}
+
+// Values are reduced to letters, digits, - and _, so a host address or a device name
+// can't add levels to a topic or act as a wildcard
+
+var topicUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+func topicValue(value string) string {
+	if value == "" {
+		return "_"
+	}
+	return topicUnsafe.ReplaceAllString(value, "_")
+}
+
+type publishMessage struct {
+	topic   string
+	key     string
+	headers [][2]string
+	retain  bool
+}
+
+// The messages to publish for an event: one per device if the template uses {device},
+// otherwise one keyed by all the devices, comma separated
+
+func (e *sinkEvent) publishMessages(template string) []publishMessage {
+	devices := []string{strings.Join(e.Devices, ",")}
+	if strings.Contains(template, "{device}") {
+		devices = e.Devices
+		if len(devices) == 0 {
+			devices = []string{publishNoDevice}
+		}
+	}
+
+	headers := [][2]string{{"EventTime", e.eventTime()}, {"Stream", e.Stream}, {"EventName", e.EventName}, {"Server", e.Server}}
+	messages := make([]publishMessage, len(devices))
+	for i, device := range devices {
+		topic := topicFields.ReplaceAllStringFunc(template, func(field string) string {
+			switch field {
+			case "{stream}":
+				return topicValue(e.Stream)
+			case "{eventname}":
+				return topicValue(e.EventName)
+			case "{server}":
+				return topicValue(e.Server)
+			case "{source}":
+				return topicValue(e.Source)
+			case "{user}":
+				return topicValue(e.User)
+			case "{host}":
+				return topicValue(e.Host)
+			case "{datastore}":
+				return topicValue(e.Datastore)
+			case "{device}":
+				return topicValue(device)
+			}
+			return field
+		})
+		messages[i] = publishMessage{topic: topic, key: device, headers: headers}
+	}
+	return messages
+}
+
+//**********
+// Clients
+//**********
+
+// Webhooks publishing to the same servers with the same settings share a client, across
+// config reloads too
+
+var publishClients = struct {
+	sync.Mutex
+	byKey map[string]publisher
+}{byKey: make(map[string]publisher)}
+
+func publishClientFor(hook *webhook, create func() publisher) publisher {
+	p := hook.Publish
+	key := fmt.Sprintf("%s %v %s %s %s %s %s %v %+v", hook.sinkType, p.Servers, p.User, p.Password, p.Acks, p.Version, p.ClientID,
+		hook.publishTimeout(), hook.TLS)
+
+	publishClients.Lock()
+	defer publishClients.Unlock()
+	client, found := publishClients.byKey[key]
+	if !found {
+		client = create()
+		publishClients.byKey[key] = client
+	}
+	return client
+}
+
+// Close the clients no live webhook uses, after a reload
+
+func closeUnusedPublishClients(live webhooks) {
+	used := map[publisher]bool{}
+	for _, hook := range live {
+		if s, ok := hook.sink.(*publishSink); ok {
+			used[s.client] = true
+		}
+	}
+
+	publishClients.Lock()
+	defer publishClients.Unlock()
+	for key, client := range publishClients.byKey {
+		if !used[client] {
+			delete(publishClients.byKey, key)
+			if err := client.close(); err != nil {
+				logger.Warn("(publishClients) closing unused client", "err", err)
+			}
+		}
+	}
+}
+
+// The sink itself is just the topic templates; the client does the publishing
+
+type publishSink struct {
+	template       string
+	retain         bool
+	retainTemplate string
+	client         publisher
+}
+
+type publisher interface {
+	publish(messages []publishMessage, body []byte) error
+	close() error
+}
+
+func (s *publishSink) send(sub streamSubscriber, body []byte) error {
+	event, err := decodeSinkEvent(sub, body)
+	if err != nil {
+		return err
+	}
+	messages := event.publishMessages(s.template)
+	for i := range messages {
+		messages[i].retain = s.retain
+	}
+	if s.retainTemplate != "" {
+		for _, m := range event.publishMessages(s.retainTemplate) {
+			m.retain = true
+			messages = append(messages, m)
+		}
+	}
+	return s.client.publish(messages, body)
+}
+
+func (hook *webhook) newPublishSink() (sink, error) {
+	tlsConfig, err := hook.publishTLS()
+	if err != nil {
+		return nil, err
+	}
+
+	client := publishClientFor(hook, func() publisher {
+		switch hook.sinkType {
+		case SINK_NATS:
+			return newNatsPublisher(hook.Publish, tlsConfig, hook.publishTimeout())
+		case SINK_MQTT:
+			return newMQTTPublisher(hook.Publish, tlsConfig, hook.publishTimeout())
+		}
+		return newKafkaPublisher(hook.Publish, tlsConfig, hook.publishTimeout())
+	})
+	return &publishSink{template: hook.Publish.Topic, retain: hook.Publish.Retain, retainTemplate: hook.Publish.RetainTopic, client: client}, nil
+}
+
+//**********
+// Kafka
+//**********
+
+type kafkaPublisher struct {
+	writer  *kafka.Writer
+	timeout time.Duration
+}
+
+// Messages are hashed to partitions by key, so each device's events stay in order.
+// Templated topics may not exist yet; the broker's auto.create.topics.enable decides
+
+func newKafkaPublisher(config *PublishSink, tlsConfig *tls.Config, timeout time.Duration) *kafkaPublisher {
+	transport := &kafka.Transport{
+		ClientID:    "nsoevent",
+		DialTimeout: timeout,
+		TLS:         tlsConfig,
+	}
+	if config.User != "" {
+		transport.SASL = plain.Mechanism{Username: config.User, Password: config.Password}
+	}
+	return &kafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(config.Servers...),
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafkaAcks[strings.ToLower(config.Acks)],
+			BatchTimeout:           10 * time.Millisecond,
+			AllowAutoTopicCreation: true,
+			Transport:              transport,
+		},
+		timeout: timeout,
+	}
+}
+
+func (k *kafkaPublisher) publish(messages []publishMessage, body []byte) error {
+	records := make([]kafka.Message, len(messages))
+	for i, m := range messages {
+		records[i] = kafka.Message{Topic: m.topic, Value: body}
+		if m.key != "" {
+			records[i].Key = []byte(m.key)
+		}
+		for _, h := range m.headers {
+			if h[1] != "" {
+				records[i].Headers = append(records[i].Headers, kafka.Header{Key: h[0], Value: []byte(h[1])})
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), k.timeout)
+	defer cancel()
+	return k.writer.WriteMessages(ctx, records...)
+}
+
+func (k *kafkaPublisher) close() error {
+	return k.writer.Close()
+}
+
+//**********
+// NATS
+//**********
+
+// Connected on first use, so that checking the config or a dry run doesn't need the
+// servers. The key goes in a Key header, NATS having no such thing
+
+type natsPublisher struct {
+	mu        sync.Mutex
+	config    *PublishSink
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	conn      *nats.Conn
+}
+
+func newNatsPublisher(config *PublishSink, tlsConfig *tls.Config, timeout time.Duration) *natsPublisher {
+	return &natsPublisher{config: config, tlsConfig: tlsConfig, timeout: timeout}
+}
+
+func (p *natsPublisher) connect() (*nats.Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil && !p.conn.IsClosed() {
+		return p.conn, nil
+	}
+
+	options := []nats.Option{nats.Name("nsoevent"), nats.Timeout(p.timeout), nats.MaxReconnects(-1)}
+	if p.config.User != "" {
+		options = append(options, nats.UserInfo(p.config.User, p.config.Password))
+	}
+	if p.tlsConfig != nil {
+		options = append(options, nats.Secure(p.tlsConfig))
+	}
+	conn, err := nats.Connect(strings.Join(p.config.Servers, ","), options...)
+	if err != nil {
+		return nil, err
+	}
+	p.conn = conn
+	return conn, nil
+}
+
+// Flushing waits for the server to have the messages, so a failure counts against the
+// webhook as it would for HTTP
+
+func (p *natsPublisher) publish(messages []publishMessage, body []byte) error {
+	conn, err := p.connect()
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		msg := nats.NewMsg(m.topic)
+		msg.Data = body
+		if m.key != "" {
+			msg.Header.Set("Key", m.key)
+		}
+		for _, h := range m.headers {
+			if h[1] != "" {
+				msg.Header.Set(h[0], h[1])
+			}
+		}
+		if err := conn.PublishMsg(msg); err != nil {
+			return err
+		}
+	}
+	return conn.FlushTimeout(p.timeout)
+}
+
+func (p *natsPublisher) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+)
+
+// The publishing sinks, against an embedded NATS server and a Kafka stand-in that speaks
+// just enough of the protocol for a producer
+
+const publishEventTime = "2026-10-18T09:15:02.318504+00:00"
+
+var publishDevices = []string{"ATX_PE_1", "ATX_RTR_Lamar", "CT_RTR_McCampbell"}
+
+// A decoded config change on three devices, and a subscriber to send it for
+
+func publishTestEvent(t *testing.T) (streamSubscriber, []byte) {
+	event, err := os.ReadFile("testdata/decoders/NETCONF/config-change-multi-device.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := decodeEvent("NETCONF", event)
+	if result.Error != "" {
+		t.Fatal(result.Error)
+	}
+	sub := streamSubscriber{
+		server: &NsoServer{name: "nso1"},
+		stream: &Stream{Name: "NETCONF", server: "nso1"},
+		url:    &url.URL{Host: "nso1.example.com:8080"},
+	}
+	return sub, result.Body
+}
+
+func publishTestHook(t *testing.T, sinkType string, publish *PublishSink) *webhook {
+	hook := &webhook{Stream: "NETCONF", Type: sinkType, Publish: publish, Timeout: 5 * time.Second}
+	var err error
+	if hook.sinkType, err = parseSinkType(hook.Type); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Publish.validate(hook.sinkType); err != nil {
+		t.Fatal(err)
+	}
+	if hook.sink, err = hook.newSink(); err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+func TestPublishMessages(t *testing.T) {
+	sub, body := publishTestEvent(t)
+	event, err := decodeSinkEvent(sub, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := event.eventTime(); got != publishEventTime {
+		t.Errorf("eventTime() = %q, want %q", got, publishEventTime)
+	}
+
+	tests := []struct {
+		template string
+		topics   []string
+		keys     []string
+	}{
+		{defaultPublishTopic, []string{"nso.NETCONF.netconf-config-change"}, []string{"ATX_PE_1,ATX_RTR_Lamar,CT_RTR_McCampbell"}},
+		{"nso/{server}/{user}/{host}", []string{"nso/nso1/jenkins/10_20_0_15"}, []string{"ATX_PE_1,ATX_RTR_Lamar,CT_RTR_McCampbell"}},
+		{"nso.{device}", []string{"nso.ATX_PE_1", "nso.ATX_RTR_Lamar", "nso.CT_RTR_McCampbell"}, publishDevices},
+	}
+	for _, test := range tests {
+		var topics, keys []string
+		for _, m := range event.publishMessages(test.template) {
+			topics = append(topics, m.topic)
+			keys = append(keys, m.key)
+		}
+		if !reflect.DeepEqual(topics, test.topics) || !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%s: topics %q keys %q, want %q %q", test.template, topics, keys, test.topics, test.keys)
+		}
+	}
+
+	event.Devices = nil
+	if m := event.publishMessages("nso.{device}"); len(m) != 1 || m[0].topic != "nso.none" {
+		t.Errorf("no devices: got %+v, want one message to nso.none", m)
+	}
+	if err := checkTopicTemplate("nso.{stream}.{device_name}"); err == nil {
+		t.Error("checkTopicTemplate accepted an unknown field")
+	}
+}
+
+//**********
+// NATS
+//**********
+
+func TestNatsSink(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+
+	conn, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	received, err := conn.SubscribeSync("nso.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	hook := publishTestHook(t, "nats", &PublishSink{Servers: []string{ns.ClientURL()}, Topic: "nso.{stream}.{device}"})
+	sub, body := publishTestEvent(t)
+	if err := hook.sink.send(sub, body); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, device := range publishDevices {
+		msg, err := received.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", device, err)
+		}
+		if want := "nso.NETCONF." + device; msg.Subject != want {
+			t.Errorf("subject %s, want %s", msg.Subject, want)
+		}
+		if key := msg.Header.Get("Key"); key != device {
+			t.Errorf("%s: Key header %q, want %q", msg.Subject, key, device)
+		}
+		if eventTime := msg.Header.Get("EventTime"); eventTime != publishEventTime {
+			t.Errorf("%s: EventTime header %q, want %q", msg.Subject, eventTime, publishEventTime)
+		}
+		if string(msg.Data) != string(body) {
+			t.Errorf("%s: data differs from the payload", msg.Subject)
+		}
+	}
+}
+
+//**********
+// Kafka
+//**********
+
+type kafkaRecord struct {
+	topic   string
+	key     string
+	value   string
+	headers map[string]string
+}
+
+// One broker leading one partition of every topic asked about (as if the broker creates
+// them), which acknowledges and keeps whatever is produced to it
+
+type kafkaStandIn struct {
+	listener net.Listener
+	mu       sync.Mutex
+	topics   []string
+	records  []kafkaRecord
+}
+
+func newKafkaStandIn(t *testing.T) *kafkaStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &kafkaStandIn{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go k.serve(t, conn)
+		}
+	}()
+	return k
+}
+
+func (k *kafkaStandIn) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	host, portString, _ := net.SplitHostPort(k.listener.Addr().String())
+	port, _ := strconv.Atoi(portString)
+
+	for {
+		apiVersion, correlationID, _, request, err := protocol.ReadRequest(reader)
+		if err != nil {
+			if err != io.EOF {
+				t.Logf("kafka stand-in: %v", err)
+			}
+			return
+		}
+
+		var response protocol.Message
+		switch r := request.(type) {
+		case *apiversions.Request:
+			response = &apiversions.Response{ApiKeys: []apiversions.ApiKeyResponse{
+				{ApiKey: int16(protocol.ApiVersions), MinVersion: 0, MaxVersion: 2},
+				{ApiKey: int16(protocol.Metadata), MinVersion: 1, MaxVersion: 1},
+				{ApiKey: int16(protocol.Produce), MinVersion: 3, MaxVersion: 3},
+			}}
+		case *metadata.Request:
+			m := &metadata.Response{Brokers: []metadata.ResponseBroker{{NodeID: 0, Host: host, Port: int32(port)}}}
+			for _, topic := range k.knownTopics(r.TopicNames) {
+				m.Topics = append(m.Topics, metadata.ResponseTopic{Name: topic, Partitions: []metadata.ResponsePartition{
+					{PartitionIndex: 0, LeaderID: 0, ReplicaNodes: []int32{0}, IsrNodes: []int32{0}},
+				}})
+			}
+			response = m
+		case *produce.Request:
+			p := &produce.Response{}
+			for _, topic := range r.Topics {
+				result := produce.ResponseTopic{Topic: topic.Topic}
+				for _, partition := range topic.Partitions {
+					k.keep(t, topic.Topic, partition.RecordSet)
+					result.Partitions = append(result.Partitions, produce.ResponsePartition{Partition: partition.Partition})
+				}
+				p.Topics = append(p.Topics, result)
+			}
+			response = p
+		default:
+			t.Errorf("kafka stand-in: unexpected %T", request)
+			return
+		}
+		if err := protocol.WriteResponse(conn, apiVersion, correlationID, response); err != nil {
+			t.Logf("kafka stand-in: %v", err)
+			return
+		}
+	}
+}
+
+// An empty list asks about all the topics there are
+
+func (k *kafkaStandIn) knownTopics(names []string) []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, name := range names {
+		if !slices.Contains(k.topics, name) {
+			k.topics = append(k.topics, name)
+		}
+	}
+	if len(names) == 0 {
+		return k.topics
+	}
+	return names
+}
+
+func (k *kafkaStandIn) keep(t *testing.T, topic string, records protocol.RecordSet) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for {
+		record, err := records.Records.ReadRecord()
+		if err != nil {
+			return
+		}
+		kept := kafkaRecord{topic: topic, headers: map[string]string{}}
+		if record.Key != nil {
+			key, _ := protocol.ReadAll(record.Key)
+			kept.key = string(key)
+		}
+		value, _ := protocol.ReadAll(record.Value)
+		kept.value = string(value)
+		for _, h := range record.Headers {
+			kept.headers[h.Key] = string(h.Value)
+		}
+		k.records = append(k.records, kept)
+	}
+}
+
+func TestKafkaSink(t *testing.T) {
+	k := newKafkaStandIn(t)
+	defer k.listener.Close()
+
+	hook := publishTestHook(t, "kafka", &PublishSink{Servers: []string{k.listener.Addr().String()}})
+	sub, body := publishTestEvent(t)
+	if err := hook.sink.send(sub, body); err != nil {
+		t.Fatal(err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.records) != 1 {
+		t.Fatalf("got %d records, want 1", len(k.records))
+	}
+	r := k.records[0]
+	if r.topic != "nso.NETCONF.netconf-config-change" {
+		t.Errorf("topic %s, want nso.NETCONF.netconf-config-change", r.topic)
+	}
+	if r.key != "ATX_PE_1,ATX_RTR_Lamar,CT_RTR_McCampbell" {
+		t.Errorf("key %q, want the devices", r.key)
+	}
+	if r.headers["EventTime"] != publishEventTime || r.headers["EventName"] != "netconf-config-change" {
+		t.Errorf("headers %v, want EventTime %s and EventName netconf-config-change", r.headers, publishEventTime)
+	}
+	if r.value != string(body) {
+		t.Error("value differs from the payload")
+	}
+}
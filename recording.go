@@ -0,0 +1,252 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A recording (subscribe --record) is a JSON lines file with one received notification
+// per line: when it arrived, where from, and the notification XML as NSO sent it. The
+// replay command pushes it back through the handlers and webhooks, and mock-server can
+// serve it as event streams
+
+type recordedEvent struct {
+	Received time.Time `json:"received"`
+	Server   string    `json:"server,omitempty"`
+	Source   string    `json:"source,omitempty"` // host:port of the stream URL
+	Stream   string    `json:"stream"`
+	Event    string    `json:"event"`
+}
+
+type eventRecorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+var recorder *eventRecorder
+
+func openRecorder(path string) (*eventRecorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetEscapeHTML(false)
+	return &eventRecorder{file: file, encoder: encoder}, nil
+}
+
+// Write one notification, before any handler has looked at it. Safe to call on a nil
+// recorder
+
+func (r *eventRecorder) record(sub streamSubscriber, n *Notification) {
+	if r == nil {
+		return
+	}
+	event := recordedEvent{
+		Received: time.Now(),
+		Server:   sub.server.name,
+		Source:   sub.url.Host,
+		Stream:   sub.stream.Name,
+		Event:    string(n.Raw),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.encoder.Encode(event); err != nil {
+		logger.Error("(eventRecorder:record) writing recording", "file", r.file.Name(), "stream", sub.stream.label(), "err", err)
+	}
+}
+
+// Keeps what the stream decoder has read, so each notification can be recorded exactly as
+// it arrived rather than rebuilt from the decoded fields
+
+type rawCapture struct {
+	reader io.Reader
+	buffer []byte
+	offset int64 // stream offset of buffer[0]
+}
+
+func (c *rawCapture) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.buffer = append(c.buffer, p[:n]...)
+	return n, err
+}
+
+// Everything up to the decoder's offset, which is just past the element it decoded. What
+// the decoder has read beyond that is kept for the next one
+
+func (c *rawCapture) take(end int64) []byte {
+	element := make([]byte, end-c.offset)
+	copy(element, c.buffer)
+	c.buffer = append(c.buffer[:0], c.buffer[end-c.offset:]...)
+	c.offset = end
+	return element
+}
+
+// The event's XML from the bytes read for it: from the start tag on, without the "data:"
+// field name SSE puts at the start of each line
+
+func sseData(element []byte) []byte {
+	if start := bytes.IndexByte(element, '<'); start >= 0 {
+		element = element[start:]
+	}
+	lines := bytes.Split(element, []byte("\n"))
+	for i := 1; i < len(lines); i++ {
+		if data, found := bytes.CutPrefix(lines[i], []byte("data:")); found {
+			lines[i] = bytes.TrimPrefix(data, []byte(" "))
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+func (r *eventRecorder) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.file.Close(); err != nil {
+		logger.Error("(eventRecorder:close) closing recording", "file", r.file.Name(), "err", err)
+	}
+}
+
+func readRecording(path string) ([]recordedEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []recordedEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // config changes can be large
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event recordedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if event.Stream == "" || event.Event == "" {
+			return nil, fmt.Errorf("%s:%d: missing stream or event", path, line)
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+//**********
+// Replay
+//**********
+
+// Stand-ins for the servers and streams that were recorded, so the webhooks link to them
+// just as they would to the real ones
+
+func replaySubscribers(events []recordedEvent) (nsoServers, map[string]*streamSubscriber) {
+	var servers nsoServers
+	subscribers := map[string]*streamSubscriber{}
+
+	for _, e := range events {
+		key := e.Server + "/" + e.Stream
+		if subscribers[key] != nil {
+			continue
+		}
+		s := servers.find(e.Server)
+		if s == nil {
+			s = &NsoServer{name: e.Server, StreamList: new(StreamList)}
+			servers = append(servers, s)
+		}
+		stream := &Stream{Name: e.Stream, server: e.Server}
+		s.StreamList.Stream = append(s.StreamList.Stream, stream)
+		subscribers[key] = &streamSubscriber{
+			server:  s,
+			stream:  stream,
+			url:     &url.URL{Host: e.Source},
+			handler: (*Notification).handlerDefault,
+			health:  new(subscriberHealth),
+		}
+	}
+
+	var list subscriberList
+	for _, sub := range subscribers {
+		list = append(list, sub)
+	}
+	list.registerHandlers()
+
+	return servers, subscribers
+}
+
+// Push a recording through the handlers and webhooks in order. The gaps between events are
+// divided by speed (0 sends them back to back)
+
+func replayRecording(path string, speed float64) error {
+	events, err := readRecording(path)
+	if err != nil {
+		return fmt.Errorf("(replayRecording) %v", err)
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("(replayRecording) no events in %s", path)
+	}
+
+	servers, subscribers := replaySubscribers(events)
+	if err := Config().webhooks.validate(servers); err != nil {
+		logger.Warn("(replayRecording) "+err.Error(), "file", path)
+	}
+	if debugEnabled() {
+		Config().webhooks.print()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info(stringColorize("### replaying", COLOR_HIGHLIGHT), "file", path, "events", len(events), "speed", speed, "dryRun", Config().dryRun)
+	replayed := 0
+	for i, e := range events {
+		if i > 0 && speed > 0 {
+			if gap := time.Duration(float64(e.Received.Sub(events[i-1].Received)) / speed); gap > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(gap):
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			logger.Warn("(replayRecording) interrupted", "replayed", replayed, "remaining", len(events)-i)
+			break
+		}
+
+		sub := subscribers[e.Server+"/"+e.Stream]
+		n := newNotification()
+		if err := xml.Unmarshal([]byte(e.Event), n); err != nil {
+			logger.Error("(replayRecording) bad event XML", "stream", sub.stream.label(), "event", i+1, "err", err)
+			continue
+		}
+		replayed++
+
+		if body, ok := sub.handleNotification(n); ok {
+			sub.fireWebhooks(Config(), n, body, true)
+		}
+	}
+
+	drainAndShutdown()
+	logger.Info("replay complete", "replayed", replayed)
+	return nil
+}
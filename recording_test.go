@@ -0,0 +1,88 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Events are recorded exactly as NSO sent them, without the SSE framing, and the same
+// whether they fill one read or several
+
+func TestRecordRaw(t *testing.T) {
+	var want [][]byte
+	stream := &bytes.Buffer{}
+	for _, name := range []string{"config-change-multi-device.xml", "config-change-candidate-no-user.xml"} {
+		event, err := os.ReadFile(filepath.Join("testdata/decoders/NETCONF", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		event = bytes.TrimSpace(event)
+		want = append(want, event)
+		writeSSE(stream, event)
+	}
+
+	sub := streamSubscriber{server: &NsoServer{name: "nso1"}, stream: &Stream{Name: "NETCONF", server: "nso1"},
+		url: &url.URL{Host: "nso1.example.com:8080"}}
+	readers := map[string]io.Reader{
+		"one read":        bytes.NewReader(stream.Bytes()),
+		"a byte per read": &oneByteReader{data: stream.Bytes()},
+	}
+	for name, reader := range readers {
+		path := filepath.Join(t.TempDir(), "events.jsonl")
+		r, err := openRecorder(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw := &rawCapture{reader: reader}
+		d := xml.NewDecoder(raw)
+		for {
+			n := newNotification()
+			if err := d.Decode(&n); err != nil {
+				break
+			}
+			n.Raw = sseData(raw.take(d.InputOffset()))
+			r.record(sub, n)
+		}
+		r.close()
+
+		events, err := readRecording(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != len(want) {
+			t.Fatalf("%s: %d events recorded, want %d", name, len(events), len(want))
+		}
+		for i, e := range events {
+			if e.Event != string(want[i]) {
+				t.Errorf("%s: event %d recorded as\n%s\nwant\n%s", name, i+1, e.Event, want[i])
+			}
+			if e.Server != "nso1" || e.Stream != "NETCONF" || e.Source != "nso1.example.com:8080" {
+				t.Errorf("%s: event %d from %s/%s at %s", name, i+1, e.Server, e.Stream, e.Source)
+			}
+		}
+	}
+}
+
+// Hands out a byte at a time, like the slowest of streams
+
+type oneByteReader struct {
+	data []byte
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	p[0], r.data = r.data[0], r.data[1:]
+	return 1, nil
+}
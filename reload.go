@@ -0,0 +1,145 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Reload the config file while subscribed. The new config is processed and its webhooks
+// checked before anything changes; if either fails the old config stays in effect, along
+// with the settings and sources the files were read into. On success the new config is
+// published in one step, its webhooks take over the shared files, connections and target
+// guards, each stream's webhooks are swapped, and subscribers are started or stopped to
+// match the new set of requested streams.
+//
+// Settings fixed at startup (NSO connection, ordering mode, listener ports, logging) need a
+// restart
+
+func (g *subscriberGroup) reload(reason string) {
+	g.reloadLock.Lock()
+	defer g.reloadLock.Unlock()
+
+	logger.Info("reloading config", "reason", reason, "file", viper.ConfigFileUsed())
+
+	files := saveConfigFiles()
+	reject := func(err error) {
+		files.restore()
+		logger.Error("config reload rejected, keeping current config", "err", err)
+	}
+	if err := loadConfigFiles(); err != nil {
+		reject(err)
+		return
+	}
+
+	current := Config()
+	next, err := readConfig(true)
+	if err != nil {
+		reject(err)
+		return
+	}
+
+	// Keep the settings that can't change without a restart
+
+	next.nsoTargets = current.nsoTargets
+	next.ordering = current.ordering
+	next.connectTimeout = current.connectTimeout
+	next.readTimeout = current.readTimeout
+
+	if err := next.webhooks.check(); err != nil {
+		reject(err)
+		return
+	}
+
+	liveConfig.Store(next)
+	next.webhooks.register()
+	next.webhooks.link(g.servers)
+	logger.Info("webhooks reloaded", "webhooks", len(next.webhooks))
+	if debugEnabled() {
+		Config().webhooks.print()
+	}
+
+	g.retire(current.webhooks, next.shutdownGrace)
+	g.resubscribe()
+}
+
+// Events the old webhooks were holding back for coalescing go out now, and any still on
+// their way to an old webhook are delivered without being held. Once those deliveries have
+// had the shutdown grace period to finish, the files and connections that no webhook uses
+// any more are closed
+
+func (g *subscriberGroup) retire(previous webhooks, grace time.Duration) {
+	for _, hook := range previous {
+		if hook.coalescer != nil {
+			hook.coalescer.close()
+		}
+	}
+
+	time.AfterFunc(grace, func() {
+		g.reloadLock.Lock()
+		defer g.reloadLock.Unlock()
+
+		for _, hook := range previous {
+			if hook.client != nil {
+				hook.client.CloseIdleConnections()
+			}
+		}
+		live := Config().webhooks
+		closeUnusedFiles(live)
+		closeUnusedSyslogConns(live)
+		closeUnusedPublishClients(live)
+	})
+}
+
+// Compare the requested streams against what's running, stopping subscribers that are no
+// longer wanted and starting new ones. Called with the reload lock held
+
+func (g *subscriberGroup) resubscribe() {
+	wanted, err := g.servers.newSubscriberList(Config().streamNames)
+	if err != nil {
+		logger.Error("stream list not changed", "err", err)
+		return
+	}
+
+	key := func(sub *streamSubscriber) string {
+		return sub.stream.label() + " " + sub.url.String()
+	}
+
+	// A failover repoints running subscribers at the new primary, so they're only read
+	// with the list lock held
+
+	running := map[string]*streamSubscriber{}
+	labels := map[*streamSubscriber]string{}
+	streamSubscriberListLock.RLock()
+	for _, sub := range streamSubscriberList {
+		running[key(sub)] = sub
+		labels[sub] = sub.stream.label()
+	}
+	streamSubscriberListLock.RUnlock()
+
+	var next subscriberList
+	for _, sub := range wanted {
+		if existing, found := running[key(sub)]; found {
+			next = append(next, existing)
+			delete(running, key(sub))
+			continue
+		}
+		logger.Info("subscribing to added stream", "stream", sub.stream.label())
+		g.start(sub)
+		next = append(next, sub)
+	}
+
+	for _, sub := range running {
+		logger.Info("unsubscribing from removed stream", "stream", labels[sub])
+		sub.cancel()
+	}
+
+	streamSubscriberListLock.Lock()
+	streamSubscriberList = next
+	streamSubscriberListLock.Unlock()
+}
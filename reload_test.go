@@ -0,0 +1,110 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// The long shutdownGrace keeps the replaced webhooks' retirement, which closes any sink
+// the live config doesn't use, from reaching into other tests
+
+const reloadTestConfig = `
+nso:
+  restconfAPI: http://127.0.0.1:8080
+shutdownGrace: 1h
+webhooks:
+  - stream: NETCONF
+    url: http://127.0.0.1:9/hook
+    rateLimit:
+      rate: %RATE%
+  - stream: NETCONF
+    type: file
+    file:
+      path: %PATH%
+      maxSize: %SIZE%
+      keep: 2
+`
+
+func writeReloadTestConfig(t *testing.T, file, events, rate, size, extra string) {
+	config := strings.NewReplacer("%RATE%", rate, "%PATH%", events, "%SIZE%", size).Replace(reloadTestConfig) + extra
+	if err := os.WriteFile(file, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A reload rejected by the webhook checks leaves the running webhooks' guards and files,
+// and the settings the config files were read into, as they were
+
+func TestReloadRejected(t *testing.T) {
+	dir := t.TempDir()
+	file, events := filepath.Join(dir, "config.yaml"), filepath.Join(dir, "events.jsonl")
+
+	viper.Reset()
+	defer viper.Reset()
+	viper.SetConfigFile(file)
+	defer func() {
+		targetGuards.Lock()
+		delete(targetGuards.byURL, "http://127.0.0.1:9/hook")
+		targetGuards.Unlock()
+		closeUnusedFiles(nil)
+	}()
+
+	writeReloadTestConfig(t, file, events, "5", "1", "")
+	if err := loadConfigFiles(); err != nil {
+		t.Fatal(err)
+	}
+	running, err := readConfig(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	liveConfig.Store(running)
+	if err := running.webhooks.validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	guard, rotating := running.webhooks[0].guard, running.webhooks[1].sink.(*rotatingFile)
+
+	// New limits, and a webhook whose command doesn't exist
+
+	writeReloadTestConfig(t, file, events, "50", "5", `
+  - stream: NETCONF
+    type: exec
+    exec:
+      command: /nonexistent/nsoevent-hook
+`)
+	g := &subscriberGroup{ctx: context.Background()}
+	g.reload("test")
+
+	if Config() != running {
+		t.Fatal("rejected config was published")
+	}
+	targetGuards.Lock()
+	current := targetGuards.byURL["http://127.0.0.1:9/hook"]
+	targetGuards.Unlock()
+	if current != guard || guard.rateLimit.Rate != 5 {
+		t.Errorf("guard replaced or changed, rate %v", current.rateLimit.Rate)
+	}
+	if rotating.maxSize != 1024*1024 || rotating.keep != 2 {
+		t.Errorf("file maxSize %d keep %d, want 1MB and 2", rotating.maxSize, rotating.keep)
+	}
+	if hooks, _ := viper.Get("webhooks").([]interface{}); len(hooks) != 2 || len(configSources) != 1 || configSources[0].webhooks != 2 {
+		t.Errorf("config files left at %d webhooks, sources %+v", len(hooks), configSources)
+	}
+
+	// The same change without the broken webhook goes through
+
+	writeReloadTestConfig(t, file, events, "50", "5", "")
+	g.reload("test")
+	if Config() == running || Config().webhooks[0].guard.rateLimit.Rate != 50 || rotating.maxSize != 5*1024*1024 {
+		t.Error("valid reload not applied")
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redacted          = "********"
+	secretExecTimeout = 10 * time.Second
+	minScrubLength    = 8
+)
+
+// Credentials in the config can be given directly or as a reference, resolved when the
+// config is processed:
+//
+//   env:VAR            the value of environment variable VAR
+//   file:/path         the contents of a file (e.g. a Docker or Kubernetes secret)
+//   exec:command args  the output of a command, run through the shell
+//
+// Surrounding whitespace (the trailing newline of a file or command) is removed
+
+var secretKeys = map[string]bool{
+	"password": true,
+	"apitoken": true,
+	"token":    true,
+}
+
+func isSecretRef(value string) bool {
+	scheme, _, found := strings.Cut(value, ":")
+	return found && (scheme == "env" || scheme == "file" || scheme == "exec")
+}
+
+func resolveSecret(value string) (string, error) {
+	if !isSecretRef(value) {
+		rememberSecret(value)
+		return value, nil
+	}
+
+	scheme, ref, _ := strings.Cut(value, ":")
+	var secret string
+	switch scheme {
+	case "env":
+		v, found := os.LookupEnv(ref)
+		if !found {
+			return "", fmt.Errorf("environment variable %s not set", ref)
+		}
+		secret = v
+	case "file":
+		data, err := os.ReadFile(ref)
+		if err != nil {
+			return "", err
+		}
+		secret = string(data)
+	case "exec":
+		ctx, cancel := context.WithTimeout(context.Background(), secretExecTimeout)
+		defer cancel()
+		out, err := exec.CommandContext(ctx, "sh", "-c", ref).Output()
+		if err != nil {
+			return "", fmt.Errorf("command '%s': %v", ref, err)
+		}
+		secret = string(out)
+	}
+
+	secret = strings.TrimSpace(secret)
+	rememberSecret(secret)
+	return secret, nil
+}
+
+// Resolved secrets are remembered so they can be scrubbed from log output, wherever they
+// turn up
+
+var knownSecrets = struct {
+	sync.RWMutex
+	values map[string]bool
+}{values: make(map[string]bool)}
+
+func rememberSecret(secret string) {
+	if len(secret) < minScrubLength { // e.g. the default "admin" would mangle unrelated text
+		return
+	}
+	knownSecrets.Lock()
+	knownSecrets.values[secret] = true
+	knownSecrets.Unlock()
+}
+
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+func scrubSecrets(s string) string {
+	knownSecrets.RLock()
+	defer knownSecrets.RUnlock()
+	for secret := range knownSecrets.values {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+// A webhook URL as it may be shown: any user info is removed and the query, which can
+// carry an API key, is masked. Anything that isn't a URL with either is left alone
+
+func redactURL(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || (u.User == nil && u.RawQuery == "") {
+		return target
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		u.RawQuery = redacted
+	}
+	return u.String()
+}
+
+// Used by both log handlers: credential attributes are masked, webhook URLs redacted, and
+// known secrets are scrubbed from any other string value
+
+func redactAttr(a slog.Attr) slog.Attr {
+	if secretKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redactSecret(a.Value.String()))
+	}
+	if a.Key == "webhook" && a.Value.Kind() == slog.KindString {
+		return slog.String(a.Key, scrubSecrets(redactURL(a.Value.String())))
+	}
+	if a.Value.Kind() == slog.KindString {
+		return slog.String(a.Key, scrubSecrets(a.Value.String()))
+	}
+	return a
+}
@@ -0,0 +1,120 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultShutdownGrace = 30 * time.Second
+
+// Tracks in-flight work (event handlers and webhook deliveries) so shutdown can drain it
+// instead of killing it mid-POST. Unlike a WaitGroup, work may start from zero while
+// someone is waiting, as a breaker's probe does when its timer fires
+
+type workTracker struct {
+	mu    sync.Mutex
+	count int64
+	idle  chan struct{} // closed when the count drops back to zero
+}
+
+var inflight workTracker
+
+func (t *workTracker) start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.count == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.count++
+}
+
+func (t *workTracker) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.count--
+	if t.count == 0 {
+		close(t.idle)
+	}
+}
+
+func (t *workTracker) pending() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
+}
+
+// Wait for in-flight work to complete, giving up after the grace period. Returns the
+// amount of work still outstanding
+
+func (t *workTracker) wait(grace time.Duration) int64 {
+	timeout := time.NewTimer(grace)
+	defer timeout.Stop()
+	for {
+		t.mu.Lock()
+		if t.count == 0 {
+			t.mu.Unlock()
+			return 0
+		}
+		idle := t.idle
+		t.mu.Unlock()
+
+		select {
+		case <-idle:
+		case <-timeout.C:
+			return t.pending()
+		}
+	}
+}
+
+// Called once every subscriber has stopped reading. Anything held back for coalescing is
+// sent immediately, then in-flight handlers and deliveries get up to the grace period to
+// finish before checkpoints are flushed and anything left over is reported
+
+func drainAndShutdown() {
+	logger.Info("shutting down, draining in-flight events", "inflight", inflight.pending(), "grace", Config().shutdownGrace.String())
+
+	for _, hook := range Config().webhooks {
+		if hook.coalescer != nil {
+			hook.coalescer.flushAll()
+		}
+	}
+
+	deadline := time.Now().Add(Config().shutdownGrace)
+	abandoned := inflight.wait(Config().shutdownGrace)
+
+	// A breaker probe firing from here on wouldn't be waited for, so stop them. One that
+	// started in the meantime gets whatever is left of the grace period
+
+	guards := allTargetGuards()
+	for _, g := range guards {
+		g.stop()
+	}
+	if abandoned == 0 {
+		abandoned = inflight.wait(time.Until(deadline))
+	}
+
+	if err := checkpoints.save(); err != nil {
+		logger.Error("saving checkpoints", "file", Config().checkpointFile, "err", err)
+	}
+
+	queued := 0
+	for _, g := range guards {
+		g.mu.Lock()
+		if len(g.queue) > 0 {
+			logger.Warn("abandoned queued deliveries", "webhook", g.url, "count", len(g.queue))
+			queued += len(g.queue)
+		}
+		g.mu.Unlock()
+	}
+
+	if abandoned > 0 || queued > 0 {
+		logger.Warn("shutdown complete with work abandoned", "inflight", abandoned, "queued", queued)
+	} else {
+		logger.Info("shutdown complete, all events drained")
+	}
+}
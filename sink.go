@@ -0,0 +1,433 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFileMaxSize = 100 // MB
+	defaultFileKeep    = 5
+	defaultExecTimeout = 30 * time.Second
+)
+
+// Where a webhook delivers its matched events. An HTTP POST is the default; the other sinks
+// go through the same filters, coalescing, rate limit and circuit breaker
+
+type SinkType int
+
+const (
+	SINK_HTTP SinkType = iota
+	SINK_FILE
+	SINK_STDOUT
+	SINK_EXEC
+	SINK_SYSLOG
+	SINK_KAFKA
+	SINK_NATS
+	SINK_MQTT
+)
+
+func (t SinkType) String() string {
+	return [...]string{"http", "file", "stdout", "exec", "syslog", "kafka", "nats", "mqtt"}[t]
+}
+
+func parseSinkType(s string) (SinkType, error) {
+	switch strings.ToLower(s) {
+	case "", "http", "https":
+		return SINK_HTTP, nil
+	case "file":
+		return SINK_FILE, nil
+	case "stdout":
+		return SINK_STDOUT, nil
+	case "exec":
+		return SINK_EXEC, nil
+	case "syslog":
+		return SINK_SYSLOG, nil
+	case "kafka":
+		return SINK_KAFKA, nil
+	case "nats":
+		return SINK_NATS, nil
+	case "mqtt":
+		return SINK_MQTT, nil
+	}
+	return SINK_HTTP, fmt.Errorf("unknown webhook type '%s' (expected http, file, stdout, exec, syslog, kafka, nats or mqtt)", s)
+}
+
+type sink interface {
+	send(sub streamSubscriber, body []byte) error
+}
+
+// Settings for the file sink: JSON lines, rotated when the file reaches MaxSize MB. The
+// last Keep files are kept as PATH.1 (newest) to PATH.Keep
+
+type FileSink struct {
+	Path    string
+	MaxSize int // MB
+	Keep    int
+}
+
+// Settings for the exec sink: the command is run once per event with the payload on stdin
+// and the main fields in EVENT_* environment variables
+
+type ExecSink struct {
+	Command string
+	Args    []string
+}
+
+func (hook *webhook) newSink() (sink, error) {
+	switch hook.sinkType {
+	case SINK_FILE:
+		return fileSinkFor(hook.File), nil
+	case SINK_STDOUT:
+		return stdoutSink{}, nil
+	case SINK_EXEC:
+		if _, err := exec.LookPath(hook.Exec.Command); err != nil {
+			return nil, err
+		}
+		return &execSink{config: hook.Exec, timeout: hook.execTimeout()}, nil
+	case SINK_SYSLOG:
+		return hook.newSyslogSink()
+	case SINK_KAFKA, SINK_NATS, SINK_MQTT:
+		return hook.newPublishSink()
+	}
+	return httpSink{hook: hook}, nil
+}
+
+// Everything newSink needs, checked without opening or sharing anything
+
+func (hook *webhook) checkSink() error {
+	switch hook.sinkType {
+	case SINK_FILE:
+		dir := filepath.Dir(hook.File.Path)
+		if info, err := os.Stat(dir); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	case SINK_EXEC:
+		_, err := exec.LookPath(hook.Exec.Command)
+		return err
+	case SINK_SYSLOG:
+		if hook.Syslog.Network == "tls" && hook.TLS != nil {
+			_, err := hook.TLS.clientConfig()
+			return err
+		}
+	case SINK_KAFKA, SINK_NATS, SINK_MQTT:
+		_, err := hook.publishTLS()
+		return err
+	}
+	return nil
+}
+
+// What the webhook delivers to, for logs and metrics
+
+func (hook *webhook) target() string {
+	switch hook.sinkType {
+	case SINK_FILE:
+		return "file:" + hook.File.Path
+	case SINK_STDOUT:
+		return "stdout"
+	case SINK_EXEC:
+		return "exec:" + hook.Exec.Command
+	case SINK_SYSLOG:
+		if hook.Syslog.Network == "journald" {
+			return "journald:" + hook.Syslog.Address
+		}
+		return "syslog:" + hook.Syslog.Network + ":" + hook.Syslog.Address
+	case SINK_KAFKA, SINK_NATS, SINK_MQTT:
+		return hook.sinkType.String() + ":" + strings.Join(hook.Publish.Servers, ",") + "/" + hook.Publish.Topic
+	}
+	return hook.Url
+}
+
+func (webhooks webhooks) useStdout() bool {
+	for _, hook := range webhooks {
+		if hook.sinkType == SINK_STDOUT && !hook.Disable {
+			return true
+		}
+	}
+	return false
+}
+
+// The payload fields the sinks that don't just pass the JSON along build their messages
+// from, plus the count of events merged by debounce/dedupe
+
+type sinkEvent struct {
+	enrichData
+	Count int `json:"count,omitempty"`
+}
+
+func decodeSinkEvent(sub streamSubscriber, body []byte) (*sinkEvent, error) {
+	event := new(sinkEvent)
+	if err := json.Unmarshal(body, event); err != nil {
+		return nil, fmt.Errorf("decoding payload: %v", err)
+	}
+	if event.Stream == "" {
+		event.Stream = sub.stream.Name
+	}
+	return event, nil
+}
+
+// The notification's eventTime, from the original XML carried in the payload
+
+func (e *sinkEvent) eventTime() string {
+	var event struct {
+		EventTime string `xml:"eventTime"`
+	}
+	if err := xml.Unmarshal([]byte(e.Event), &event); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(event.EventTime)
+}
+
+//**********
+// HTTP
+//**********
+
+type httpSink struct {
+	hook *webhook
+}
+
+func (s httpSink) send(sub streamSubscriber, body []byte) error {
+	return s.hook.post(sub, body)
+}
+
+//**********
+// File
+//**********
+
+// Webhooks writing to the same file share it, across config reloads too, so rotation
+// happens in one place. processConfig makes sure they agree on maxSize and keep; a reload
+// that changes them applies to the shared file
+
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+var fileSinks = struct {
+	sync.Mutex
+	byPath map[string]*rotatingFile
+}{byPath: make(map[string]*rotatingFile)}
+
+func fileSinkFor(config *FileSink) *rotatingFile {
+	fileSinks.Lock()
+	defer fileSinks.Unlock()
+
+	f, found := fileSinks.byPath[config.Path]
+	if !found {
+		f = &rotatingFile{path: config.Path}
+		fileSinks.byPath[config.Path] = f
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.maxSize = int64(config.MaxSize) * 1024 * 1024
+	f.keep = config.Keep
+	return f
+}
+
+// Close the files no live webhook writes to, after a reload
+
+func closeUnusedFiles(live webhooks) {
+	used := map[string]bool{}
+	for _, hook := range live {
+		if hook.sinkType == SINK_FILE {
+			used[hook.File.Path] = true
+		}
+	}
+
+	fileSinks.Lock()
+	defer fileSinks.Unlock()
+	for path, f := range fileSinks.byPath {
+		if !used[path] {
+			delete(fileSinks.byPath, path)
+			f.close()
+		}
+	}
+}
+
+func (f *rotatingFile) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file != nil {
+		_ = f.file.Close()
+		f.file = nil
+	}
+}
+
+func (f *rotatingFile) send(sub streamSubscriber, body []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file != nil && f.size+int64(len(body)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			logger.Error("(rotatingFile:send) rotating", "stream", sub.stream.label(), "file", f.path, "err", err)
+		}
+	}
+	if f.file == nil {
+		file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+		f.file, f.size = file, info.Size()
+	}
+
+	n, err := f.file.Write(oneLine(body))
+	f.size += int64(n)
+	return err
+}
+
+// PATH.N-1 becomes PATH.N and so on, the current file becomes PATH.1, and the next write
+// starts a new one
+
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+	if f.keep <= 0 {
+		return os.Remove(f.path)
+	}
+	for i := f.keep - 1; i > 0; i-- {
+		_ = os.Rename(f.path+"."+strconv.Itoa(i), f.path+"."+strconv.Itoa(i+1))
+	}
+	return os.Rename(f.path, f.path+".1")
+}
+
+//**********
+// stdout
+//**********
+
+// Events go to stdout, one JSON document per line, for piping into jq. The logs move to
+// stderr to keep out of the way
+
+var stdoutLock sync.Mutex
+
+type stdoutSink struct{}
+
+func (stdoutSink) send(_ streamSubscriber, body []byte) error {
+	stdoutLock.Lock()
+	defer stdoutLock.Unlock()
+	_, err := os.Stdout.Write(oneLine(body))
+	return err
+}
+
+// The payloads are compact JSON; make sure each ends its line
+
+func oneLine(body []byte) []byte {
+	if len(body) > 0 && body[len(body)-1] == '\n' {
+		return body
+	}
+	return append(body[:len(body):len(body)], '\n')
+}
+
+//**********
+// exec
+//**********
+
+type execSink struct {
+	config  *ExecSink
+	timeout time.Duration
+}
+
+// The webhook's timeout covers the whole command, defaulting to something more generous
+// than an HTTP request gets
+
+func (hook *webhook) execTimeout() time.Duration {
+	if hook.Timeout > 0 {
+		return hook.Timeout
+	}
+	return defaultExecTimeout
+}
+
+func (s *execSink) send(sub streamSubscriber, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, s.config.Command, s.config.Args...)
+	ownProcessGroup(cmd) // so a control-C doesn't kill it mid-drain
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(), eventEnv(body)...)
+	output, err := cmd.CombinedOutput()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command timed out after %v: %s", s.timeout, bytes.TrimSpace(output))
+	}
+	if err != nil {
+		return fmt.Errorf("command failed, %v: %s", err, bytes.TrimSpace(output))
+	}
+	logger.Debug("(execSink:send) command output", "stream", sub.stream.label(), "webhook", "exec:"+s.config.Command, "output", string(output))
+	return nil
+}
+
+// The payload's main fields, for commands that don't want to parse JSON
+
+var execEnvNames = map[string]string{
+	"source":    "EVENT_SOURCE",
+	"server":    "EVENT_SERVER",
+	"stream":    "EVENT_STREAM",
+	"eventname": "EVENT_NAME",
+	"user":      "EVENT_USER",
+	"host":      "EVENT_HOST",
+	"datastore": "EVENT_DATASTORE",
+	"devices":   "EVENT_DEVICES", // comma separated
+	"count":     "EVENT_COUNT",   // events merged by debounce/dedupe
+}
+
+func eventEnv(body []byte) []string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil
+	}
+
+	var env []string
+	for field, name := range execEnvNames {
+		raw, found := fields[field]
+		if !found {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			continue
+		}
+		switch v := value.(type) {
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			env = append(env, name+"="+strings.Join(items, ","))
+		default:
+			env = append(env, name+"="+fmt.Sprint(v))
+		}
+	}
+	sort.Strings(env)
+	return env
+}
@@ -0,0 +1,144 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The file, stdout and exec sinks
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	f := &rotatingFile{path: path, maxSize: 100, keep: 2}
+	defer f.close()
+	sub, _ := publishTestEvent(t)
+
+	// 40 bytes a line: two fit in a file, the third starts a new one
+
+	line := func(i int) []byte { return []byte(`{"event":` + strings.Repeat("0", 29) + string(rune('0'+i)) + "}") }
+	for i := 1; i <= 7; i++ {
+		if err := f.send(sub, line(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]string{
+		path:        string(line(7)) + "\n",
+		path + ".1": string(line(5)) + "\n" + string(line(6)) + "\n",
+		path + ".2": string(line(3)) + "\n" + string(line(4)) + "\n",
+	}
+	for file, content := range want {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), data, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 kept, want only %d rotated files", filepath.Base(path), f.keep)
+	}
+}
+
+func TestFileSinkShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shared.jsonl")
+	first := fileSinkFor(&FileSink{Path: path, MaxSize: 1, Keep: 3})
+	second := fileSinkFor(&FileSink{Path: path, MaxSize: 1, Keep: 3})
+	other := fileSinkFor(&FileSink{Path: path + ".other", MaxSize: 1, Keep: 3})
+	defer func() {
+		fileSinks.Lock()
+		delete(fileSinks.byPath, path)
+		delete(fileSinks.byPath, path+".other")
+		fileSinks.Unlock()
+	}()
+
+	if first != second || first == other {
+		t.Error("webhooks writing to the same file don't share it")
+	}
+	if first.maxSize != 1024*1024 || first.keep != 3 {
+		t.Errorf("maxSize %d keep %d, want 1MB and 3", first.maxSize, first.keep)
+	}
+}
+
+func TestOneLine(t *testing.T) {
+	if got := string(oneLine([]byte(`{"a":1}`))); got != "{\"a\":1}\n" {
+		t.Errorf("oneLine() = %q, want a trailing newline", got)
+	}
+	if got := string(oneLine([]byte("{\"a\":1}\n"))); got != "{\"a\":1}\n" {
+		t.Errorf("oneLine() = %q, want the line unchanged", got)
+	}
+
+	// The payload is shared by all of an event's webhooks, so it mustn't be written to
+
+	payload := []byte(`{"a":1}x`)
+	oneLine(payload[:7])
+	if string(payload) != `{"a":1}x` {
+		t.Errorf("oneLine() changed the payload's backing array to %q", payload)
+	}
+}
+
+func TestEventEnv(t *testing.T) {
+	body := []byte(`{"source":"nso1.example.com:8080","server":"nso1","stream":"NETCONF","eventname":"netconf-config-change",` +
+		`"user":"admin","devices":["R1","R0"],"edits":{"R1":[]},"event":"<notification/>","count":2}`)
+	want := []string{
+		"EVENT_COUNT=2",
+		"EVENT_DEVICES=R1,R0",
+		"EVENT_NAME=netconf-config-change",
+		"EVENT_SERVER=nso1",
+		"EVENT_SOURCE=nso1.example.com:8080",
+		"EVENT_STREAM=NETCONF",
+		"EVENT_USER=admin",
+	}
+	if got := eventEnv(body); !reflect.DeepEqual(got, want) {
+		t.Errorf("eventEnv()\n got %q\nwant %q", got, want)
+	}
+	if got := eventEnv([]byte("not json")); got != nil {
+		t.Errorf("eventEnv(not json) = %q, want nothing", got)
+	}
+}
+
+func TestExecSink(t *testing.T) {
+	sub, body := publishTestEvent(t)
+	out := filepath.Join(t.TempDir(), "out")
+
+	// The payload on stdin and the fields in the environment
+
+	s := &execSink{config: &ExecSink{Command: "sh", Args: []string{"-c", `echo "$EVENT_STREAM $EVENT_USER" > "$0"; cat >> "$0"`, out}},
+		timeout: 5 * time.Second}
+	if err := s.send(sub, body); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "NETCONF jenkins\n" + string(body); string(data) != want {
+		t.Errorf("command got %q, want %q", data, want)
+	}
+
+	// Failing and running too long are both failed deliveries
+
+	s = &execSink{config: &ExecSink{Command: "sh", Args: []string{"-c", "echo oops; exit 3"}}, timeout: 5 * time.Second}
+	if err := s.send(sub, body); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("failing command: error %v, want its output", err)
+	}
+
+	s = &execSink{config: &ExecSink{Command: "sleep", Args: []string{"10"}}, timeout: 100 * time.Millisecond}
+	start := time.Now()
+	if err := s.send(sub, body); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("slow command: error %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("slow command ran for %v, want it stopped after the timeout", elapsed)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -74,6 +75,25 @@ type Stream struct {
 	ReplayStart   time.Time `xml:"replay-log-creation-time" json:"replay-log-creation-time"`
 	Access        []*Access `xml:"access" json:"access"`
 	Webhooks      webhooks  `xml:"-" json:"-"`
+	webhookLock   sync.RWMutex
+	server        string // name of the NSO server the stream is on
+}
+
+// How the stream is named in logs, metrics and checkpoints: qualified by the server when
+// there are several
+
+func (s *Stream) label() string {
+	if multipleNSOTargets() {
+		return s.server + "/" + s.Name
+	}
+	return s.Name
+}
+
+// Checkpoints are always kept by server and stream, so adding or removing a server doesn't
+// orphan them
+
+func (s *Stream) checkpointKey() string {
+	return s.server + "/" + s.Name
 }
 
 type StreamList struct {
@@ -81,18 +101,19 @@ type StreamList struct {
 	Stream  []*Stream `xml:"stream" json:"stream"`
 }
 
-func newStreamList(rawData []byte) (*StreamList, error) {
+func newStreamList(rawData []byte, server string, node nsoNode) (*StreamList, error) {
 	streamList := new(StreamList)
 
 	err := xml.Unmarshal(rawData, &streamList)
 	if err != nil {
-		fmt.Printf("(newStreamList) xml.Unmarshal: %v", err)
+		logger.Error("(newStreamList) xml.Unmarshal", "err", err)
 		return nil, err
 	}
 
 	// Fill in extra fields and clean up
 
 	for i, s := range streamList.Stream {
+		s.server = server
 		for j, a := range s.Access {
 			switch a.Encoding {
 			case "json":
@@ -102,7 +123,7 @@ func newStreamList(rawData []byte) (*StreamList, error) {
 			default:
 				streamList.Stream[i].Access[j].EncodingType = ENCODING_UNKNOWN
 			}
-			streamList.Stream[i].Access[j].LocationURL, err = url.Parse(fixupHostString(a.Location))
+			streamList.Stream[i].Access[j].LocationURL, err = url.Parse(fixupHostString(a.Location, node))
 			if err != nil {
 				return nil, err
 				//panic(fmt.Errorf("(newStreamList) url.Parse failed on '%s': %v", a.Location, err))
@@ -127,12 +148,22 @@ func (streamList *StreamList) findStreamsByName(targetName string) []*Stream {
 	return *list
 }
 
-// Add a pointer back to a webhook
+// The stream's webhooks are swapped as a whole when the config is reloaded, so readers
+// take a snapshot
 
-func (stream *Stream) addWebhook(webhook *webhook) {
-	debugMsgf("(stream:addWebhook) [%s]\n",
-		stringColorize(stream.Name, COLOR_STREAM))
-	stream.Webhooks = append(stream.Webhooks, webhook)
+func (stream *Stream) webhooks() webhooks {
+	stream.webhookLock.RLock()
+	defer stream.webhookLock.RUnlock()
+	return stream.Webhooks
+}
+
+func (stream *Stream) setWebhooks(hooks webhooks) {
+	for _, hook := range hooks {
+		logger.Debug("(stream:setWebhooks)", "stream", stream.Name, "webhook", hook.target())
+	}
+	stream.webhookLock.Lock()
+	defer stream.webhookLock.Unlock()
+	stream.Webhooks = hooks
 }
 
 func (streamList *StreamList) print() {
@@ -13,10 +13,11 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
-	"strconv"
 	"sync"
 	"syscall"
-	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
 )
 
 type ioStream struct {
@@ -25,38 +26,144 @@ type ioStream struct {
 
 type streamSubscriber struct {
 	done       func() <-chan struct{}
+	cancel     context.CancelFunc
+	server     *NsoServer
+	failover   <-chan struct{} // closes when a cluster fails over
 	stream     *Stream
 	url        *url.URL
 	ioStream   ioStream
 	handler    func(*Notification, streamSubscriber) (string, error)
+	dispatcher *keyedDispatcher
+	health     *subscriberHealth
 	eventCount int
+	marks      eventMarks // the event(s) being delivered, for the checkpoint
 }
 
 type subscriberList []*streamSubscriber
 
-var streamSubscriberList subscriberList
+// The lock also covers each listed subscriber's stream and url, which resolveStream
+// changes on a failover
+
+var (
+	streamSubscriberList     subscriberList
+	streamSubscriberListLock sync.RWMutex
+)
+
+func (servers nsoServers) startSubscribers() error {
+	subscribers, err := servers.newSubscriberList(Config().streamNames)
+	if err != nil {
+		return err
+	}
+
+	// Set up a master context that can stop all subscribers
+
+	cancelCtx, cancelSubscribers := context.WithCancel(context.Background())
+	group := &subscriberGroup{servers: servers, ctx: cancelCtx}
+
+	// With ordering enabled, all subscribers share one dispatcher so that a device's events
+	// stay in order even when they arrive on different streams
+
+	if Config().ordering != ORDERING_NONE {
+		group.dispatcher = newKeyedDispatcher()
+		logger.Info("event ordering enabled", "ordering", Config().ordering.String())
+	}
+
+	if err := checkpoints.load(); err != nil {
+		logger.Warn("loading checkpoints, starting without them", "file", Config().checkpointFile, "err", err)
+	}
+	go checkpoints.saveEvery(checkpointSaveInterval, cancelCtx.Done())
+
+	if Config().recordFile != "" {
+		if recorder, err = openRecorder(Config().recordFile); err != nil {
+			cancelSubscribers()
+			return fmt.Errorf("(startSubscribers) opening recording: %v", err)
+		}
+		defer recorder.close()
+		logger.Info("recording events", "file", Config().recordFile)
+	}
+
+	for _, s := range servers {
+		if s.clustered() {
+			go group.watchRole(s)
+		}
+	}
+
+	// Start the individual subscribers, publishing the list for the health endpoints
+
+	for _, sub := range subscribers {
+		group.start(sub)
+	}
+	streamSubscriberListLock.Lock()
+	streamSubscriberList = subscribers
+	streamSubscriberListLock.Unlock()
 
-func (s *NsoServer) startSubscribers() error {
+	// Reload the config on SIGHUP, or whenever the file changes if asked to
+
+	if Config().watchConfig {
+		viper.OnConfigChange(func(e fsnotify.Event) {
+			group.reload("config file " + e.Op.String())
+		})
+		viper.WatchConfig()
+	}
+
+	// Wait for all the subscribers to exit, possibly from a control-C. Either way, drain
+	// whatever is still in flight before returning
+
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		for sig := range c {
+			if sig == syscall.SIGHUP {
+				group.reload("SIGHUP")
+				continue
+			}
+			cancelSubscribers()
+			return
+		}
+	}()
+
+	group.wg.Wait()
+	cancelSubscribers()
+	drainAndShutdown()
+	return nil
+}
+
+// Subscribe to the requested streams on every server
+
+func (servers nsoServers) newSubscriberList(streamNames []string) (subscriberList, error) {
+	var subscribers subscriberList
+	for _, s := range servers {
+		serverSubscribers, err := s.newSubscriberList(streamNames)
+		if err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, serverSubscribers...)
+	}
+	return subscribers, nil
+}
 
-	// Set up list of subscribers, only to the XML streams for now. If no specific stream(s)
-	// were requested, then assume all
+// Set up list of subscribers, only to the XML streams for now. If no specific stream(s)
+// were requested, then assume all
 
-	if len(Config.streamNames) == 0 {
-		for _, availStream := range s.StreamList.Stream {
-			Config.streamNames = append(Config.streamNames, availStream.Name)
+func (s *NsoServer) newSubscriberList(streamNames []string) (subscriberList, error) {
+	streamList := s.streams()
+	if len(streamNames) == 0 {
+		for _, availStream := range streamList.Stream {
+			streamNames = append(streamNames, availStream.Name)
 		}
 	}
 
 	found := map[string]bool{}
+	var subscribers subscriberList
 
-	for _, requestStream := range Config.streamNames {
+	for _, requestStream := range streamNames {
 		found[requestStream] = false
-		for _, availStream := range s.StreamList.Stream {
+		for _, availStream := range streamList.Stream {
 			if fuzzyNameMatch(requestStream, availStream.Name) {
 				for _, a := range availStream.Access {
 					if a.EncodingType == ENCODING_XML {
 						found[requestStream] = true
-						streamSubscriberList = append(streamSubscriberList, &streamSubscriber{stream: availStream, url: a.LocationURL, handler: (*Notification).handlerDefault})
+						subscribers = append(subscribers, &streamSubscriber{server: s, stream: availStream, url: a.LocationURL, handler: (*Notification).handlerDefault, health: new(subscriberHealth)})
 					}
 				}
 			}
@@ -65,60 +172,69 @@ func (s *NsoServer) startSubscribers() error {
 
 	// Were any requested streams not found?
 
-	if len(Config.streamNames) > len(streamSubscriberList) {
+	if len(streamNames) > len(subscribers) {
 		for n, f := range found {
 			if !f {
-				fmt.Printf("stream '%s' not found\n", stringColorize(n, COLOR_ERROR))
+				logger.Error("stream not found", "stream", n, "server", s.name)
 			}
 		}
-		return fmt.Errorf("stream(s) not found")
+		return nil, fmt.Errorf("stream(s) not found on NSO server %s", s.name)
 	}
 
-	// Register handlers for the known stream types
+	subscribers.registerHandlers()
+	return subscribers, nil
+}
 
-	streamSubscriberList.registerHandler("ncs-events", (*Notification).handlerNcsEvents)
-	streamSubscriberList.registerHandler("NETCONF", (*Notification).handlerNetconf)
+// The set of running subscribers, which can change when the config is reloaded
 
-	// Set up a master context that can stop all subscribers
+type subscriberGroup struct {
+	servers    nsoServers
+	ctx        context.Context
+	wg         sync.WaitGroup
+	dispatcher *keyedDispatcher
+	reloadLock sync.Mutex
+}
 
-	cancelCtx, cancelSubscribers := context.WithCancel(context.Background())
-	var wg sync.WaitGroup
+func (g *subscriberGroup) start(sub *streamSubscriber) {
+	ctx, cancel := context.WithCancel(g.ctx)
+	sub.done = ctx.Done
+	sub.cancel = cancel
+	sub.dispatcher = g.dispatcher
 
-	// Start the individual subscribers
+	g.wg.Add(1)
+	go func(sub *streamSubscriber) {
+		defer g.wg.Done()
+		defer cancel()
+		for {
+			var generation int
+			generation, sub.failover = sub.server.haWatch()
+			events, err := sub.server.startSubscriber(*sub)
+
+			// On a cluster, a lost stream is subscribed to again, on the new primary if the
+			// cluster has failed over
+			if sub.server.clustered() && ctx.Err() == nil {
+				logger.Warn("(startSubscriber) stream lost", "stream", sub.stream.label(), "events", events, "err", err)
+				if g.streamLost(sub, generation) {
+					continue
+				}
+			}
 
-	for i := range streamSubscriberList {
-		streamSubscriberList[i].done = cancelCtx.Done
-		wg.Add(1)
-		go func(sub streamSubscriber) {
-			defer wg.Done()
-			events, err := s.startSubscriber(sub)
 			if err != nil {
-				fmt.Printf("[%s] (startSubscriber) %s after %s event%s - %s\n",
-					stringColorize(sub.stream.Name, COLOR_STREAM),
-					stringColorize("exiting", COLOR_ERROR),
-					stringColorize(strconv.Itoa(events), COLOR_HIGHLIGHT), pluralSuffix(events),
-					stringColorize(fmt.Sprintf("ERROR: %v", err), COLOR_ERROR))
+				logger.Error("(startSubscriber) exiting", "stream", sub.stream.label(), "events", events, "err", err)
 				// TODO should an error from an individual subscriber cancel them all?
 			} else {
-				fmt.Printf("[%s] (startSubscriber) %s after %s event%s\n",
-					stringColorize(sub.stream.Name, COLOR_STREAM), stringColorize("exiting", COLOR_ERROR),
-					stringColorize(strconv.Itoa(events), COLOR_HIGHLIGHT), pluralSuffix(events))
+				logger.Info("(startSubscriber) exiting", "stream", sub.stream.label(), "events", events)
 			}
-		}(*streamSubscriberList[i])
-	}
-
-	// Wait for all the subscribers to exit, possibly from a control-C
+			return
+		}
+	}(sub)
+}
 
-	go func() {
-		c := make(chan os.Signal)
-		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-		<-c
-		cancelSubscribers()
-		wg.Wait()
-	}()
+// Register handlers for the known stream types
 
-	wg.Wait()
-	return nil
+func (sl subscriberList) registerHandlers() {
+	sl.registerHandler("ncs-events", (*Notification).handlerNcsEvents)
+	sl.registerHandler("NETCONF", (*Notification).handlerNetconf)
 }
 
 func (sl subscriberList) registerHandler(streamName string, h func(*Notification, streamSubscriber) (string, error)) {
@@ -132,17 +248,25 @@ func (sl subscriberList) registerHandler(streamName string, h func(*Notification
 // Primary stream subscriber and high-level event code
 
 func (s *NsoServer) startSubscriber(sub streamSubscriber) (int, error) {
-	var err error
 
-	fmt.Printf("[%s] (NSOServer:startSubscriber) %s\n", stringColorize(sub.stream.Name, COLOR_STREAM), stringColorize(sub.url.String(), COLOR_URL))
+	logger.Info("(NSOServer:startSubscriber) subscribing", "stream", sub.stream.label(), "url", sub.url.String())
 
-	sub.ioStream.reader, err = s.openStream(sub.url)
+	reader, err := s.openStream(sub.replayURL())
 	if err != nil {
 		return 0, err
 	}
+	sub.ioStream.reader = sub.health.track(reader)
 	defer sub.ioStream.reader.Close()
 
-	d := xml.NewDecoder(sub.ioStream.reader)
+	recordSubscriberUp(sub.stream.label())
+	sub.health.up()
+	defer func() {
+		recordSubscriberDown(sub.stream.label())
+		sub.health.down()
+	}()
+
+	raw := &rawCapture{reader: sub.ioStream.reader}
+	d := xml.NewDecoder(raw)
 
 	// Wrap the event reader in a goroutine to allow checking for done signal too
 
@@ -157,6 +281,9 @@ func (s *NsoServer) startSubscriber(sub streamSubscriber) (int, error) {
 			if err := d.Decode(&n); err != nil { // Can happen if/when sub.ioStream.reader closes
 				break
 			}
+			if element := raw.take(d.InputOffset()); recorder != nil {
+				n.Raw = sseData(element)
+			}
 			out <- *n
 			n = nil // Hint to garbage collection
 		}
@@ -169,39 +296,97 @@ func (s *NsoServer) startSubscriber(sub streamSubscriber) (int, error) {
 		case <-sub.done():
 			return sub.eventCount, nil
 
+		case <-sub.failover:
+			return sub.eventCount, fmt.Errorf("NSO cluster failed over")
+
 		case n, ok := <-notificationChan:
 			if !ok {
 				return sub.eventCount, fmt.Errorf("notification channel closed/unavailable")
 			}
 
+			recorder.record(sub, &n)
+
 			// The registered stream handler will interpret the message
 
 			if sub.handler != nil {
 				sub.eventCount++
-				go func(n *Notification) {
-					logMsg := fmt.Sprintf("[%s] %s", stringColorize(sub.stream.Name, COLOR_STREAM), stringColorize(n.EventTime.Format(time.RFC3339), COLOR_HIGHLIGHT))
-					msg, err := sub.handler(n, sub)
-					if err == nil {
-						fmt.Println(logMsg + " " + msg)
-
-						// Fire the associated webhooks
-
-						innerClean := n.enrichData(sub, xmlInnerCleanup(n.Inner))
-						for _, hook := range sub.stream.Webhooks {
-							if hook.shouldFire(n, innerClean) {
-								go func(w webhook) {
-									w.fire(sub, innerClean)
-								}(*hook)
-							}
-						}
+				sub.health.event()
+				inflight.start()
+				config := Config() // one snapshot for the whole event, in case of a reload
+				event := sub
+				if !config.dryRun { // dry run events aren't really delivered
+					event.marks = checkpoints.begin(sub.stream.checkpointKey(), n.EventTime)
+				}
+				if sub.dispatcher != nil {
+					// Ordered mode: decode here, in arrival order, then queue the webhooks
+					// behind any earlier ones for the same key
+					if body, ok := event.handleNotification(&n); ok {
+						sub.dispatcher.dispatch(n.orderingKeys(sub, config.ordering), func() {
+							defer inflight.finish()
+							defer event.marks.release()
+							event.fireWebhooks(config, &n, body, true)
+						})
 					} else {
-						// TODO: Should a handler error cause the subscriber to exit?
-						fmt.Println(logMsg + stringColorize(" handler ERROR: ", COLOR_ERROR) + err.Error())
-						//return
+						event.marks.release()
+						inflight.finish()
 					}
-				}(&n)
+				} else {
+					go func(n *Notification) {
+						defer inflight.finish()
+						defer event.marks.release()
+						if body, ok := event.handleNotification(n); ok {
+							event.fireWebhooks(config, n, body, false)
+						}
+					}(&n)
+				}
+			} else {
+				logger.Warn("no handler registered!", "stream", sub.stream.label())
+			}
+		}
+	}
+}
+
+// Run the registered handler against a notification, returning the enriched webhook body
+// if the event was decoded successfully
+
+func (sub streamSubscriber) handleNotification(n *Notification) ([]byte, bool) {
+	log := logger.With("stream", sub.stream.label(), "eventTime", n.EventTime)
+	n.Server = sub.server.name
+	msg, err := sub.handler(n, sub)
+	if err != nil {
+		// TODO: Should a handler error cause the subscriber to exit?
+		log.Error("handler failed", "err", err)
+		metricHandlerErrors.WithLabelValues(sub.stream.label()).Inc()
+		return nil, false
+	}
+	metricEventsReceived.WithLabelValues(sub.stream.label(), n.EventName).Inc()
+	log.Info(msg, "event", n.EventName)
+	return n.enrichData(sub, xmlInnerCleanup(n.Inner)), true
+}
+
+// Fire the webhooks associated with the subscriber's stream. When sequential, each webhook
+// is delivered before the next one starts so the caller controls the ordering. Webhooks
+// that coalesce events are handed off and delivered later. A dry run only reports them
+
+func (sub streamSubscriber) fireWebhooks(config *configuration, n *Notification, body []byte, sequential bool) {
+	if config.dryRun {
+		sub.dryRun(n, body)
+		return
+	}
+	for _, hook := range sub.stream.webhooks() {
+		if hook.shouldFire(n, body) {
+			if hook.coalescer != nil {
+				hook.coalescer.add(sub, n, body)
+			} else if sequential {
+				hook.fire(sub, body)
 			} else {
-				fmt.Printf("[%s] no handler registered!\n", stringColorize(sub.stream.Name, COLOR_STREAM))
+				inflight.start()
+				sub.marks.hold()
+				go func(w webhook) {
+					defer inflight.finish()
+					defer sub.marks.release()
+					w.fire(sub, body)
+				}(*hook)
 			}
 		}
 	}
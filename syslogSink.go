@@ -0,0 +1,420 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultSyslogFacility = "local0"
+	defaultSyslogSeverity = "notice"
+	defaultSyslogAppName  = "nsoevent"
+	defaultSyslogUDP      = "127.0.0.1:514"
+	defaultSyslogUnix     = "/dev/log"
+	defaultJournalSocket  = "/run/systemd/journal/socket"
+	syslogWriteTimeout    = 10 * time.Second
+
+	// Structured data ID, under the example private enterprise number (RFC 5612)
+	syslogSDID = "nsoevent@32473"
+)
+
+// Settings for the syslog sink: each event becomes an RFC 5424 message, with the stream,
+// event name, user and devices as structured data. Network journald writes to the systemd
+// journal's native socket instead, with the same details as journal fields
+
+type SyslogSink struct {
+	Network         string            // udp (default), tcp, tls, unix or journald
+	Address         string            // host:port, or the socket path for unix and journald
+	Facility        string            // default local0
+	AppName         string            // default nsoevent
+	DefaultSeverity string            // default notice
+	Severity        map[string]string // by event name, e.g. ncs-commit-queue-progress: info
+	Payload         bool              // the whole JSON payload as the message, not a summary line
+}
+
+var syslogFacilities = [...]string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "audit", "alert", "clock",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"}
+
+var syslogSeverities = [...]string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+func syslogLookup(names []string, name string, what string) (int, error) {
+	switch name = strings.ToLower(name); name {
+	case "error":
+		name = "err"
+	case "warn":
+		name = "warning"
+	case "critical":
+		name = "crit"
+	case "emergency":
+		name = "emerg"
+	}
+	for i, n := range names {
+		if n == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown syslog %s '%s' (expected one of %s)", what, name, strings.Join(names, ", "))
+}
+
+// Fill in the defaults and check the names, for processConfig
+
+func (s *SyslogSink) validate() error {
+	switch s.Network = strings.ToLower(s.Network); s.Network {
+	case "", "udp":
+		s.Network = "udp"
+		if s.Address == "" {
+			s.Address = defaultSyslogUDP
+		}
+	case "tcp", "tls":
+		if s.Address == "" {
+			return fmt.Errorf("syslog network %s needs an address", s.Network)
+		}
+	case "unix":
+		if s.Address == "" {
+			s.Address = defaultSyslogUnix
+		}
+	case "journald":
+		if s.Address == "" {
+			s.Address = defaultJournalSocket
+		}
+	default:
+		return fmt.Errorf("unknown syslog network '%s' (expected udp, tcp, tls, unix or journald)", s.Network)
+	}
+
+	if s.Facility == "" {
+		s.Facility = defaultSyslogFacility
+	}
+	if _, err := syslogLookup(syslogFacilities[:], s.Facility, "facility"); err != nil {
+		return err
+	}
+	if s.AppName == "" {
+		s.AppName = defaultSyslogAppName
+	}
+	if s.DefaultSeverity == "" {
+		s.DefaultSeverity = defaultSyslogSeverity
+	}
+	if _, err := syslogLookup(syslogSeverities[:], s.DefaultSeverity, "severity"); err != nil {
+		return err
+	}
+	for event, severity := range s.Severity {
+		if _, err := syslogLookup(syslogSeverities[:], severity, "severity"); err != nil {
+			return fmt.Errorf("event %s: %v", event, err)
+		}
+	}
+	return nil
+}
+
+type syslogSink struct {
+	config   *SyslogSink
+	facility int
+	severity map[string]int
+	fallback int
+	hostname string
+	conn     *syslogConn
+}
+
+func (hook *webhook) newSyslogSink() (*syslogSink, error) {
+	s := &syslogSink{config: hook.Syslog, severity: map[string]int{}, hostname: "-"}
+	s.facility, _ = syslogLookup(syslogFacilities[:], hook.Syslog.Facility, "facility")
+	s.fallback, _ = syslogLookup(syslogSeverities[:], hook.Syslog.DefaultSeverity, "severity")
+	for event, name := range hook.Syslog.Severity {
+		s.severity[strings.ToLower(event)], _ = syslogLookup(syslogSeverities[:], name, "severity")
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		s.hostname = hostname
+	}
+
+	var tlsConfig *tls.Config
+	if hook.Syslog.Network == "tls" {
+		tlsConfig = &tls.Config{}
+		if hook.TLS != nil {
+			var err error
+			if tlsConfig, err = hook.TLS.clientConfig(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	s.conn = syslogConnFor(hook, tlsConfig)
+	return s, nil
+}
+
+func (s *syslogSink) send(sub streamSubscriber, body []byte) error {
+	event, err := decodeSinkEvent(sub, body)
+	if err != nil {
+		return err
+	}
+
+	severity, found := s.severity[strings.ToLower(event.EventName)]
+	if !found {
+		severity = s.fallback
+	}
+	message := event.summary()
+	if s.config.Payload {
+		message = string(bytes.TrimSpace(body))
+	}
+
+	if s.config.Network == "journald" {
+		return s.conn.write(s.journalEntry(severity, event, message, body))
+	}
+	return s.conn.write(s.rfc5424(time.Now(), severity, event, message))
+}
+
+// One line for people reading the log, e.g. "netconf-config-change by admin@10.0.0.1 devices R0,R1"
+
+func (e *sinkEvent) summary() string {
+	summary := e.EventName
+	if summary == "" {
+		summary = "event"
+	}
+	if e.User != "" {
+		summary += " by " + e.User
+		if e.Host != "" {
+			summary += "@" + e.Host
+		}
+	}
+	if len(e.Devices) > 0 {
+		summary += " devices " + strings.Join(e.Devices, ",")
+	}
+	if e.Count > 1 {
+		summary += fmt.Sprintf(" (%d events)", e.Count)
+	}
+	return summary + " on " + e.Stream
+}
+
+//**********
+// RFC 5424
+//**********
+
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD-ID param="value" ...] MSG, with the
+// event name as the MSGID and each device as its own device="..." parameter
+
+func (s *syslogSink) rfc5424(now time.Time, severity int, e *sinkEvent, message string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d %s [%s", s.facility*8+severity, now.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(s.hostname, 255), syslogHeaderField(s.config.AppName, 48), os.Getpid(),
+		syslogHeaderField(e.EventName, 32), syslogSDID)
+
+	params := [][2]string{{"stream", e.Stream}, {"eventname", e.EventName}, {"server", e.Server},
+		{"source", e.Source}, {"user", e.User}, {"host", e.Host}, {"datastore", e.Datastore}}
+	for _, device := range e.Devices {
+		params = append(params, [2]string{"device", device})
+	}
+	if e.Count > 1 {
+		params = append(params, [2]string{"count", strconv.Itoa(e.Count)})
+	}
+	for _, p := range params {
+		if p[1] != "" {
+			fmt.Fprintf(&b, ` %s="%s"`, p[0], syslogParamEscaper.Replace(p[1]))
+		}
+	}
+	b.WriteString("] ")
+	b.WriteString(message)
+	return b.Bytes()
+}
+
+// Header fields are printable US-ASCII without spaces, or "-" when empty
+
+func syslogHeaderField(value string, maxLength int) string {
+	field := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, value)
+	if field == "" {
+		return "-"
+	}
+	if len(field) > maxLength {
+		field = field[:maxLength]
+	}
+	return field
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+//**********
+// journald
+//**********
+
+// The native journal protocol: KEY=value lines, with values containing newlines sent as
+// KEY, newline, a little-endian 64 bit length and the value. An entry too big for one
+// datagram (a large payload) is passed in a file instead, see writeJournalFile
+
+func (s *syslogSink) journalEntry(severity int, e *sinkEvent, message string, body []byte) []byte {
+	var b bytes.Buffer
+	field := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if !strings.Contains(value, "\n") {
+			b.WriteString(key + "=" + value + "\n")
+			return
+		}
+		b.WriteString(key + "\n")
+		_ = binary.Write(&b, binary.LittleEndian, uint64(len(value)))
+		b.WriteString(value + "\n")
+	}
+
+	field("MESSAGE", message)
+	field("PRIORITY", strconv.Itoa(severity))
+	field("SYSLOG_FACILITY", strconv.Itoa(s.facility))
+	field("SYSLOG_IDENTIFIER", s.config.AppName)
+	field("NSOEVENT_STREAM", e.Stream)
+	field("NSOEVENT_EVENTNAME", e.EventName)
+	field("NSOEVENT_SERVER", e.Server)
+	field("NSOEVENT_SOURCE", e.Source)
+	field("NSOEVENT_USER", e.User)
+	field("NSOEVENT_HOST", e.Host)
+	field("NSOEVENT_DATASTORE", e.Datastore)
+	field("NSOEVENT_DEVICES", strings.Join(e.Devices, ","))
+	if e.Count > 1 {
+		field("NSOEVENT_COUNT", strconv.Itoa(e.Count))
+	}
+	field("NSOEVENT_PAYLOAD", string(bytes.TrimSpace(body)))
+	return b.Bytes()
+}
+
+//**********
+// Connections
+//**********
+
+// Webhooks sending to the same syslog server with the same settings share a connection,
+// across config reloads too. A failed write is retried once on a fresh connection
+
+type syslogConn struct {
+	mu          sync.Mutex
+	network     string
+	address     string
+	tlsConfig   *tls.Config
+	dialTimeout time.Duration
+	conn        net.Conn
+	localStream bool // the unix socket turned out to be a stream, not a datagram socket
+}
+
+var syslogConns = struct {
+	sync.Mutex
+	byKey map[string]*syslogConn
+}{byKey: make(map[string]*syslogConn)}
+
+func syslogConnFor(hook *webhook, tlsConfig *tls.Config) *syslogConn {
+	key := fmt.Sprintf("%s %s %v %+v", hook.Syslog.Network, hook.Syslog.Address, hook.ConnectTimeout, hook.TLS)
+
+	syslogConns.Lock()
+	defer syslogConns.Unlock()
+	c, found := syslogConns.byKey[key]
+	if !found {
+		c = &syslogConn{network: hook.Syslog.Network, address: hook.Syslog.Address, tlsConfig: tlsConfig, dialTimeout: hook.ConnectTimeout}
+		if c.dialTimeout <= 0 {
+			c.dialTimeout = Config().connectTimeout
+		}
+		syslogConns.byKey[key] = c
+	}
+	return c
+}
+
+// Close the connections no live webhook uses, after a reload
+
+func closeUnusedSyslogConns(live webhooks) {
+	used := map[*syslogConn]bool{}
+	for _, hook := range live {
+		if s, ok := hook.sink.(*syslogSink); ok {
+			used[s.conn] = true
+		}
+	}
+
+	syslogConns.Lock()
+	defer syslogConns.Unlock()
+	for key, c := range syslogConns.byKey {
+		if !used[c] {
+			delete(syslogConns.byKey, key)
+			c.close()
+		}
+	}
+}
+
+func (c *syslogConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		_ = c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *syslogConn) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.dialTimeout}
+	c.localStream = false
+	switch c.network {
+	case "tcp":
+		return dialer.Dial("tcp", c.address)
+	case "tls":
+		return tls.DialWithDialer(dialer, "tcp", c.address, c.tlsConfig)
+	case "unix":
+		// /dev/log is usually a datagram socket, but some syslog daemons listen on a stream
+		conn, err := dialer.Dial("unixgram", c.address)
+		if err != nil {
+			c.localStream = true
+			return dialer.Dial("unix", c.address)
+		}
+		return conn, nil
+	case "journald":
+		return dialer.Dial("unixgram", c.address)
+	}
+	return dialer.Dial("udp", c.address)
+}
+
+// TCP and TLS frame each message with its length (RFC 6587 octet counting, as RFC 5425
+// requires for TLS). Local daemons listening on a unix stream socket expect one message
+// per line instead, as Go's log/syslog sends them; datagrams carry one message each
+
+func (c *syslogConn) frame(message []byte) []byte {
+	switch {
+	case c.network == "tcp" || c.network == "tls":
+		return append([]byte(strconv.Itoa(len(message))+" "), message...)
+	case c.localStream:
+		line := bytes.TrimRight(message, "\n")
+		return append(line[:len(line):len(line)], '\n')
+	}
+	return message
+}
+
+func (c *syslogConn) write(message []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if c.conn == nil {
+			if c.conn, err = c.dial(); err != nil {
+				c.conn = nil
+				return err
+			}
+		}
+		_ = c.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+		if _, err = c.conn.Write(c.frame(message)); err == nil {
+			return nil
+		}
+		if c.network == "journald" && errors.Is(err, syscall.EMSGSIZE) {
+			return c.writeJournalFile(message)
+		}
+		_ = c.conn.Close()
+		c.conn = nil
+	}
+	return err
+}
@@ -0,0 +1,215 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The syslog sink, against local UDP and unix socket listeners standing in for a syslog
+// daemon and the journal
+
+func syslogTestHook(t *testing.T, syslog *SyslogSink, tls *TLSConfig) *webhook {
+	hook := &webhook{Stream: "NETCONF", Type: "syslog", Syslog: syslog, TLS: tls}
+	var err error
+	if hook.sinkType, err = parseSinkType(hook.Type); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.Syslog.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if hook.sink, err = hook.newSink(); err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+// A short path, unix socket paths being limited to around 100 bytes
+
+func syslogSocketPath(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "nsoevent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return filepath.Join(dir, "log")
+}
+
+func TestSyslogRFC5424(t *testing.T) {
+	s := &syslogSink{config: &SyslogSink{AppName: "nso event"}, facility: 16, hostname: "collector"}
+	e := &sinkEvent{enrichData: enrichData{Stream: "NETCONF", EventName: "netconf-config-change", Server: "nso1",
+		User: `ad"min]`, Devices: []string{"R0", "R1"}}, Count: 2}
+	now := time.Date(2026, 10, 18, 9, 15, 2, 318504000, time.UTC)
+
+	want := fmt.Sprintf(`<132>1 2026-10-18T09:15:02.318504Z collector nsoevent %d netconf-config-change `+
+		`[nsoevent@32473 stream="NETCONF" eventname="netconf-config-change" server="nso1" user="ad\"min\]" `+
+		`device="R0" device="R1" count="2"] summary`, os.Getpid())
+	if got := string(s.rfc5424(now, 4, e, "summary")); got != want {
+		t.Errorf("rfc5424()\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	hook := syslogTestHook(t, &SyslogSink{Address: listener.LocalAddr().String(), Facility: "local3",
+		Severity: map[string]string{"netconf-config-change": "warning"}}, nil)
+	sub, body := publishTestEvent(t)
+
+	// The configured severity for the event, then the default for any other
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatal(err)
+	}
+	fields["eventname"] = "commit-queue-progress"
+	other, err := jsonMarshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		body     []byte
+		pri      string
+		msgid    string
+		severity string
+	}{
+		{body, "<156>", "netconf-config-change", "warning"},
+		{other, "<157>", "commit-queue-progress", "notice"},
+	}
+	buffer := make([]byte, 65536)
+	for _, test := range tests {
+		sent := time.Now()
+		if err := hook.sink.send(sub, test.body); err != nil {
+			t.Fatal(err)
+		}
+		_ = listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := listener.ReadFrom(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		message := string(buffer[:n])
+
+		header := strings.SplitN(message, " ", 7)
+		if len(header) < 7 || header[0] != test.pri+"1" || header[3] != "nsoevent" || header[5] != test.msgid {
+			t.Fatalf("%s: header %q, want PRI %s, app nsoevent and MSGID %s", test.severity, header, test.pri, test.msgid)
+		}
+		timestamp, err := time.Parse(time.RFC3339Nano, header[1])
+		if err != nil || timestamp.Before(sent.Add(-time.Second)) || timestamp.After(time.Now().Add(time.Second)) {
+			t.Errorf("%s: timestamp %s (%v), want about %s", test.severity, header[1], err, sent.Format(time.RFC3339Nano))
+		}
+		for _, want := range []string{`[nsoevent@32473 stream="NETCONF" eventname="` + test.msgid + `" server="nso1"`,
+			` user="jenkins" host="10.20.0.15"`, ` device="ATX_PE_1" device="ATX_RTR_Lamar" device="CT_RTR_McCampbell"]`,
+			"] " + test.msgid + " by jenkins@10.20.0.15 devices ATX_PE_1,ATX_RTR_Lamar,CT_RTR_McCampbell on NETCONF"} {
+			if !strings.Contains(message, want) {
+				t.Errorf("%s: message %q\nmissing %q", test.severity, message, want)
+			}
+		}
+	}
+}
+
+// Stream connections: TCP gets octet-counted messages, a local unix stream socket one
+// message per line
+
+func TestSyslogStreamFraming(t *testing.T) {
+	path := syslogSocketPath(t)
+	unixListener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unixListener.Close()
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+
+	sub, body := publishTestEvent(t)
+	tests := []struct {
+		network  string
+		listener net.Listener
+		read     func(*bufio.Reader) (string, error)
+	}{
+		{"tcp", tcpListener, func(r *bufio.Reader) (string, error) {
+			prefix, err := r.ReadString(' ')
+			if err != nil {
+				return "", err
+			}
+			length, err := strconv.Atoi(strings.TrimSuffix(prefix, " "))
+			if err != nil {
+				return "", err
+			}
+			message := make([]byte, length)
+			_, err = io.ReadFull(r, message)
+			return string(message), err
+		}},
+		{"unix", unixListener, func(r *bufio.Reader) (string, error) {
+			line, err := r.ReadString('\n')
+			return strings.TrimSuffix(line, "\n"), err
+		}},
+	}
+	for _, test := range tests {
+		hook := syslogTestHook(t, &SyslogSink{Network: test.network, Address: test.listener.Addr().String(), Payload: true}, nil)
+		for i := 0; i < 2; i++ {
+			if err := hook.sink.send(sub, body); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		conn, err := test.listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reader := bufio.NewReader(conn)
+		for i := 0; i < 2; i++ {
+			message, err := test.read(reader)
+			if err != nil {
+				t.Fatalf("%s message %d: %v", test.network, i+1, err)
+			}
+			if !strings.HasPrefix(message, "<133>1 ") || !strings.HasSuffix(message, string(bytes.TrimSpace(body))) {
+				t.Errorf("%s message %d: %q, want the payload as an RFC 5424 message", test.network, i+1, message)
+			}
+		}
+		_ = conn.Close()
+	}
+}
+
+// Webhooks only share a connection when everything about it is the same
+
+func TestSyslogConnSharing(t *testing.T) {
+	address := "127.0.0.1:6514"
+	plain := syslogTestHook(t, &SyslogSink{Network: "tls", Address: address}, nil)
+	insecure := syslogTestHook(t, &SyslogSink{Network: "tls", Address: address}, &TLSConfig{Insecure: true})
+	again := syslogTestHook(t, &SyslogSink{Network: "tls", Address: address}, &TLSConfig{Insecure: true})
+	tcp := syslogTestHook(t, &SyslogSink{Network: "tcp", Address: address}, nil)
+
+	conn := func(hook *webhook) *syslogConn { return hook.sink.(*syslogSink).conn }
+	if conn(plain) == conn(insecure) || conn(plain) == conn(tcp) {
+		t.Error("webhooks with different settings share a connection")
+	}
+	if conn(insecure) != conn(again) {
+		t.Error("webhooks with the same settings don't share a connection")
+	}
+	if !conn(insecure).tlsConfig.InsecureSkipVerify || conn(plain).tlsConfig.InsecureSkipVerify {
+		t.Error("connection has the wrong TLS settings")
+	}
+}
@@ -57,14 +57,14 @@ func pluralSuffix(n int) string {
 // NSO's stream locations may need tweaking. For example, 'localhost' should be replaced
 // by the actual IP, as well as fixing up port numbers if the target NSO happens to be
 // running inside a container
-// TODO: What do these locations look like if NSO is running as a cluster?
+// For an HA cluster the node is the current primary, whose stream list is being read
 
-func fixupHostString(s string) string {
-	newS := restconfApiRE.ReplaceAllString(s, Config.nsoTarget.apiUrl)
+func fixupHostString(s string, node nsoNode) string {
+	newS := restconfApiRE.ReplaceAllString(s, node.apiUrl)
 	if strings.Index(newS, "localhost") == -1 {
 		return newS
 	}
-	return strings.Replace(newS, "localhost", Config.nsoTarget.ipAddress, 1)
+	return strings.Replace(newS, "localhost", node.ipAddress, 1)
 }
 
 // Make the comparison a little less exact
@@ -77,7 +77,7 @@ func fuzzyNameMatch(s string, target string) bool {
 
 // Simple output colors
 func stringColorize(s string, c int) string {
-	if Config.noColor {
+	if Config().noColor {
 		return s
 	}
 	return fmt.Sprintf("%s[%dm%s%s[%dm", ESCAPE, c, s, ESCAPE, COLOR_RESET)
@@ -88,7 +88,7 @@ func stringColorizeRightPad(s string, l int) string {
 	if l == 0 {
 		return s
 	}
-	if Config.noColor {
+	if Config().noColor {
 		return s + strings.Repeat(" ", l-len(s))
 	}
 	return s + strings.Repeat(" ", l-len(s)+9)
@@ -111,6 +111,15 @@ func stringMaxLen(l *[]string) int {
 	return maxLen
 }
 
+// Shorten a string for display, marking where it was cut
+
+func stringTruncate(s string, l int) string {
+	if len(s) <= l {
+		return s
+	}
+	return s[:l] + "..."
+}
+
 // Some NSO description strings have embedded newlines and repeated spaces
 func stringCleanup(s string) string {
 	return strings.ReplaceAll(strings.Replace(s, "\n", " ", -1), "  ", "")
@@ -8,6 +8,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -15,7 +16,10 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Filter struct {
@@ -25,71 +29,220 @@ type Filter struct {
 
 type webhook struct {
 	Stream   string
+	Servers  []string // NSO server names, all servers if empty
 	Disable  bool
+	Type     string // http (default), file, stdout, exec, syslog, kafka, nats or mqtt
 	Url      string
 	User     string
 	ApiToken string
 	Token    string
 	//Filter     map[string]string
-	Filter     *Filter
-	StreamList []*Stream
-	targetURL  *url.URL
+	Filter         *Filter
+	Debounce       time.Duration
+	MaxWait        time.Duration // longest a debounce holds an event, default 10 × debounce
+	Dedupe         *Dedupe
+	RateLimit      *RateLimit
+	CircuitBreaker *CircuitBreaker
+	TLS            *TLSConfig
+	Proxy          string        // proxy URL, "none", or empty to use HTTP(S)_PROXY
+	ConnectTimeout time.Duration // defaults to nso.connectTimeout
+	Timeout        time.Duration // whole request, defaults to the connect timeout
+	File           *FileSink
+	Exec           *ExecSink
+	Syslog         *SyslogSink
+	Publish        *PublishSink
+	StreamList     []*Stream
+	sinkType       SinkType
+	sink           sink
+	targetURL      *url.URL
+	client         *http.Client
+	coalescer      *coalescer
+	guard          *targetGuard
+	invalid        bool // failed check, so not registered
 }
 
 type webhooks []*webhook
 
-func (webhooks webhooks) validate(streamList *StreamList) {
-	if hookCount := len(webhooks); hookCount > 0 {
+// Check the webhooks and link them to the servers' streams. Webhooks with an invalid URL
+// or filter are disabled, and an error is returned so a config reload can be rejected
 
-		// Check the webhook URLs
-		for _, hook := range webhooks {
-			targetUrl, err := url.Parse(hook.Url)
-			if err != nil {
-				fmt.Printf("%s: config webhook URL '%s': %v\n",
-					stringColorize("ERROR", COLOR_ERROR), stringColorize(hook.Url, COLOR_ERROR), err.Error())
-				hook.StreamList = nil
-				hook.Disable = true
-			} else {
-				hook.targetURL = targetUrl
-			}
+func (webhooks webhooks) validate(servers nsoServers) error {
+	err := webhooks.check()
+	webhooks.register()
+	webhooks.link(servers)
+	return err
+}
+
+// Everything here belongs to the webhook itself; nothing shared with other webhooks or
+// the running config is touched until register
+
+func (webhooks webhooks) check() error {
+	invalid := 0
+	for _, hook := range webhooks {
+		problems := hook.check()
+		for _, err := range problems {
+			logger.Error("config webhook", "stream", hook.Stream, "webhook", hook.target(), "err", err)
+		}
+		if len(problems) > 0 {
+			hook.StreamList = nil
+			hook.Disable = true
+			hook.invalid = true
+			invalid += len(problems)
+		}
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid webhook setting%s", invalid, pluralSuffix(invalid))
+	}
+	return nil
+}
+
+// Check the webhook URL, or whatever else the webhook delivers to, and its filters. Also
+// used by config validate, so nothing is opened or connected to
+
+func (hook *webhook) check() []error {
+	var problems []error
+
+	if hook.sinkType == SINK_HTTP {
+		targetUrl, err := url.Parse(hook.Url)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("URL: %v", err))
+		} else {
+			hook.targetURL = targetUrl
+		}
+
+		client, err := hook.newClient()
+		if err != nil {
+			problems = append(problems, fmt.Errorf("client settings: %v", err))
+		}
+		hook.client = client
+	}
+
+	if err := hook.checkSink(); err != nil {
+		problems = append(problems, err)
+	}
+
+	if hook.Debounce > 0 || hook.Dedupe != nil {
+		hook.coalescer = newCoalescer(hook)
+	}
 
-			if hook.Filter != nil {
-				for _, n := range hook.Filter.Node {
-					value, valueOk := (*n)["value"]
-					if valueOk {
-						_, err := regexp.Compile(value)
-						if err != nil {
-							fmt.Printf("%s: config webhook for '%s': invalid filter '%s': regexp('%s')\n",
-								stringColorize("ERROR", COLOR_ERROR),
-								stringColorize(hook.Stream, COLOR_ERROR),
-								stringColorize("value", COLOR_HIGHLIGHT),
-								stringColorize(value, COLOR_ERROR))
-							hook.StreamList = nil
-							hook.Disable = true
-						}
-					}
+	if hook.Filter != nil {
+		for _, n := range hook.Filter.Node {
+			if value, valueOk := (*n)["value"]; valueOk {
+				if _, err := regexp.Compile(value); err != nil {
+					problems = append(problems, fmt.Errorf("invalid filter 'value' regexp '%s': %v", value, err))
 				}
 			}
 		}
+	}
+	return problems
+}
 
-		// Find the stream reference for each webhook
-		for _, hook := range webhooks {
-			hook.StreamList = streamList.findStreamsByName(hook.Stream)
+// Attach the checked webhooks to their sinks and target guards, which can be shared with
+// other webhooks and with the config being replaced. Only done once a config is accepted,
+// so a rejected reload leaves the running webhooks' files, connections and guards alone
+
+func (webhooks webhooks) register() {
+	claimed := map[*targetGuard]bool{}
+	for _, hook := range webhooks {
+		if hook.invalid {
+			continue
+		}
+		sink, err := hook.newSink()
+		if err != nil {
+			logger.Error("config webhook "+hook.sinkType.String(), "stream", hook.Stream, "webhook", hook.target(), "err", err)
+			hook.StreamList = nil
+			hook.Disable = true
+			hook.invalid = true
+			continue
+		}
+		hook.sink = sink
+		if hook.RateLimit != nil || hook.CircuitBreaker != nil {
+			hook.guard = targetGuardFor(hook, claimed)
 		}
+	}
+}
 
-		// Generate a warning if a webhook references no known stream(s). Otherwise link
-		// the stream(s) back to the webhook(s)
-		for _, hook := range webhooks {
-			if hook.StreamList == nil {
-				fmt.Printf("%s: config webhook reference to stream(s) '%s' not found on server\n",
-					stringColorize("WARNING", COLOR_WARNING), stringColorize(hook.Stream, COLOR_WARNING))
-			} else {
-				for _, stream := range hook.StreamList {
-					stream.addWebhook(hook)
-				}
+// Each webhook gets its own client so that TLS and proxy settings stay with their target
+
+func (hook *webhook) newClient() (*http.Client, error) {
+	var tlsConfig *tls.Config
+	if hook.TLS != nil {
+		var err error
+		if tlsConfig, err = hook.TLS.clientConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	connectTimeout := hook.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = Config().connectTimeout
+	}
+	client := newHTTPClient(tlsConfig, connectTimeout)
+
+	switch hook.Proxy {
+	case "":
+	case "none":
+		client.Transport.(*http.Transport).Proxy = nil
+	default:
+		proxyUrl, err := url.Parse(hook.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %v", err)
+		}
+		client.Transport.(*http.Transport).Proxy = http.ProxyURL(proxyUrl)
+	}
+	return client, nil
+}
+
+func (hook *webhook) timeout() time.Duration {
+	if hook.Timeout > 0 {
+		return hook.Timeout
+	}
+	if hook.ConnectTimeout > 0 {
+		return hook.ConnectTimeout
+	}
+	return Config().connectTimeout
+}
+
+// Point every stream at its (complete) new list of webhooks in one step
+
+func (hooks webhooks) link(servers nsoServers) {
+	byStream := map[*Stream]webhooks{}
+
+	// Find the stream reference for each webhook on each of its servers. Generate a warning
+	// if a webhook references no known stream(s)
+	for _, hook := range hooks {
+		hook.StreamList = nil
+		for _, s := range servers {
+			if hook.selects(s) {
+				hook.StreamList = append(hook.StreamList, s.findStreamsByName(hook.Stream)...)
 			}
 		}
+		if hook.StreamList == nil {
+			logger.Warn("config webhook reference to stream(s) not found on server", "stream", hook.Stream, "webhook", hook.target())
+		}
+		for _, stream := range hook.StreamList {
+			byStream[stream] = append(byStream[stream], hook)
+		}
+	}
+
+	for _, s := range servers {
+		for _, stream := range s.streams().Stream {
+			stream.setWebhooks(byStream[stream])
+		}
+	}
+}
+
+func (hook *webhook) selects(s *NsoServer) bool {
+	if len(hook.Servers) == 0 {
+		return true
+	}
+	for _, name := range hook.Servers {
+		if name == s.name {
+			return true
+		}
 	}
+	return false
 }
 
 func (webhooks webhooks) print() {
@@ -100,17 +253,61 @@ func (webhooks webhooks) print() {
 			if hook.Disable {
 				disableFlag = stringColorize("(DISABLED)", COLOR_HI_RED)
 			}
-			streamCount := len(hook.StreamList)
-			debugMsgf(" -> %s[%s:", disableFlag, stringColorize(hook.Stream, COLOR_WEBHOOK))
+			streams := make([]string, len(hook.StreamList))
 			for i, stream := range hook.StreamList {
-				debugMsgf("%s", stringColorize(stream.Name, COLOR_STREAM))
-				if (i + 1) < streamCount {
-					debugMsgf(",")
+				streams[i] = stringColorize(stream.label(), COLOR_STREAM)
+			}
+			fmt.Printf(" -> %s[%s:%s]: target %s, token %s\n", disableFlag, stringColorize(hook.Stream, COLOR_WEBHOOK),
+				strings.Join(streams, ","), stringColorize(redactURL(hook.target()), COLOR_URL), stringColorize(redactSecret(hook.Token), COLOR_HIGHLIGHT))
+			if f := hook.File; hook.sinkType == SINK_FILE {
+				fmt.Printf("    file: max size %dMB, keep %d\n", f.MaxSize, f.Keep)
+			}
+			if e := hook.Exec; hook.sinkType == SINK_EXEC && len(e.Args) > 0 {
+				fmt.Printf("    exec: args %q\n", e.Args)
+			}
+			if s := hook.Syslog; hook.sinkType == SINK_SYSLOG {
+				fmt.Printf("    syslog: facility %s, app %s, severity %s", s.Facility, s.AppName, s.DefaultSeverity)
+				events := make([]string, 0, len(s.Severity))
+				for event := range s.Severity {
+					events = append(events, event)
+				}
+				sort.Strings(events)
+				for _, event := range events {
+					fmt.Printf(", %s %s", event, s.Severity[event])
+				}
+				fmt.Println()
+			}
+			if p := hook.Publish; hook.sinkType == SINK_MQTT {
+				fmt.Printf("    mqtt: version %s, qos %d, retain %v", p.Version, p.QoS, p.Retain)
+				if p.RetainTopic != "" {
+					fmt.Printf(", retained copy to %s", p.RetainTopic)
 				}
+				fmt.Println()
+			}
+			if len(hook.Servers) > 0 {
+				fmt.Printf("    servers: %s\n", strings.Join(hook.Servers, ", "))
+			}
+			hook.Filter.print()
+			if hook.Debounce > 0 {
+				fmt.Printf("    debounce: %v, max wait %v\n", hook.Debounce, hook.MaxWait)
+			}
+			if hook.Dedupe != nil {
+				fmt.Printf("    dedupe: window %v, fields %v\n", hook.Dedupe.Window, hook.Dedupe.Fields)
 			}
-			debugMsgf("]: target %s, token %s\n", stringColorize(hook.Url, COLOR_URL), stringColorize(hook.Token, COLOR_HIGHLIGHT))
-			if Config.debug {
-				hook.Filter.print()
+			if hook.RateLimit != nil {
+				fmt.Printf("    rate limit: %v/s, burst %d\n", hook.RateLimit.Rate, hook.RateLimit.Burst)
+			}
+			if cb := hook.CircuitBreaker; cb != nil {
+				fmt.Printf("    circuit breaker: %d failures, cooldown %v, %s (queue %d)\n", cb.Failures, cb.Cooldown, cb.Policy, cb.QueueSize)
+			}
+			if t := hook.TLS; t != nil {
+				fmt.Printf("    tls: ca %q, cert %q, serverName %q, insecure %v\n", t.CA, t.Cert, t.ServerName, t.Insecure)
+			}
+			if hook.Proxy != "" {
+				fmt.Printf("    proxy: %s\n", hook.Proxy)
+			}
+			if hook.ConnectTimeout > 0 || hook.Timeout > 0 {
+				fmt.Printf("    timeouts: connect %v, request %v\n", hook.ConnectTimeout, hook.timeout())
 			}
 		}
 	}
@@ -130,12 +327,37 @@ func (f *Filter) print() {
 	}
 }
 
+// Deliver a webhook, going through the target's rate limit and circuit breaker if it has one.
+// Delivery errors count as failures for the circuit breaker
+
 func (webhook *webhook) fire(sub streamSubscriber, body []byte) {
-	fmt.Printf("[%s] (webhook:fire) POST to %s with token '%s'\n",
-		stringColorize(sub.stream.Name, COLOR_STREAM),
-		stringColorize(webhook.Url, COLOR_URL), webhook.Token)
+	if webhook.guard != nil {
+		webhook.guard.submit(webhook, sub, body)
+		return
+	}
+	_ = webhook.deliver(sub, body)
+}
+
+func (webhook *webhook) deliver(sub streamSubscriber, body []byte) error {
+	if webhook.sinkType == SINK_HTTP {
+		return webhook.sink.send(sub, body)
+	}
+	start := time.Now()
+	err := webhook.sink.send(sub, body)
+	recordSinkDelivery(webhook.target(), err, start)
+	if err != nil {
+		logger.Error("(webhook:deliver) failed", "stream", sub.stream.label(), "webhook", webhook.target(), "err", err)
+	}
+	return err
+}
+
+// Issue the actual POST. Transport errors, server errors (5xx) and throttling (429) are
+// returned as failures for the circuit breaker
 
-	debugMsgf("[%s] (webhook:fire) POST body '%s'\n", stringColorize(sub.stream.Name, COLOR_STREAM), body)
+func (webhook *webhook) post(sub streamSubscriber, body []byte) error {
+	log := logger.With("stream", sub.stream.label(), "webhook", webhook.Url)
+	log.Info("(webhook:fire) POST", "token", webhook.Token)
+	log.Debug("(webhook:fire) POST body", "body", string(body))
 
 	// Construct the POST request
 	req := &http.Request{
@@ -153,51 +375,46 @@ func (webhook *webhook) fire(sub streamSubscriber, body []byte) {
 	}
 
 	// Issue the request with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), Config.connectTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), webhook.timeout())
 	defer cancel()
 
-	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	start := time.Now()
+	resp, err := webhook.client.Do(req.WithContext(ctx))
 	if err != nil {
+		recordWebhookDelivery(webhook.Url, 0, start)
 		if e, ok := err.(net.Error); ok && e.Timeout() {
-			fmt.Printf("[%s] (webhook:fire) URL '%s' timeout\n",
-				stringColorize(sub.stream.Name, COLOR_STREAM),
-				stringColorize(webhook.Url, COLOR_URL))
+			log.Error("(webhook:fire) URL timeout")
 		} else {
-			fmt.Printf("[%s] (webhook:fire) %s: %s\n",
-				stringColorize(sub.stream.Name, COLOR_STREAM),
-				stringColorize("ERROR", COLOR_ERROR),
-				stringColorize(err.Error(), COLOR_ERROR))
+			log.Error("(webhook:fire) POST failed", "err", err)
 		}
-		return
+		return err
 	}
 	responseData, _ := ioutil.ReadAll(resp.Body)
 	_ = resp.Body.Close()
+	recordWebhookDelivery(webhook.Url, resp.StatusCode, start)
 
 	// Process return
 
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		log.Error("(webhook:fire) server error", "status", resp.StatusCode, "response", string(responseData))
+		return fmt.Errorf("HTTP %d from %s", resp.StatusCode, webhook.Url)
+	}
+
 	if resp.StatusCode == http.StatusNotFound {
-		fmt.Printf("[%s] (webhook:fire) %s from %s: %s\n",
-			stringColorize(sub.stream.Name, COLOR_STREAM),
-			stringColorize("NOT FOUND (404)", COLOR_ERROR),
-			stringColorize(webhook.Url, COLOR_URL), responseData)
+		log.Error("(webhook:fire) NOT FOUND (404)", "response", string(responseData))
 	} else {
-		debugMsgf("[%s] (webhook:fire) HTTP %s response from %s: %s\n",
-			stringColorize(sub.stream.Name, COLOR_STREAM),
-			stringColorize(strconv.Itoa(resp.StatusCode), COLOR_HI_BLUE),
-			stringColorize(webhook.Url, COLOR_URL), responseData)
+		log.Debug("(webhook:fire) response", "status", resp.StatusCode, "response", string(responseData))
 
 		var responseMap map[string]json.RawMessage
 		err = json.Unmarshal(responseData, &responseMap)
 		if err != nil {
-			fmt.Printf("[%s] (webhook:fire) json.Unmarshall responseData: %v\n",
-				stringColorize(sub.stream.Name, COLOR_STREAM), err)
+			log.Warn("(webhook:fire) json.Unmarshall responseData", "err", err)
 		}
 
 		var jobsMap map[string]json.RawMessage
 		err = json.Unmarshal(responseMap["jobs"], &jobsMap)
 		if err != nil {
-			fmt.Printf("[%s] (webhook:fire) json.Unmarshall responseMap: %v\n",
-				stringColorize(sub.stream.Name, COLOR_STREAM), err)
+			log.Warn("(webhook:fire) json.Unmarshall responseMap", "err", err)
 		}
 
 		// Could be multiple pipeline job results
@@ -205,17 +422,13 @@ func (webhook *webhook) fire(sub streamSubscriber, body []byte) {
 			var pipelineMap map[string]json.RawMessage
 			err = json.Unmarshal(v, &pipelineMap)
 			if err != nil {
-				fmt.Printf("[%s] (webhook:fire) %s: json.Unmarshall jobsMap[%s]: %v\n",
-					stringColorize(sub.stream.Name, COLOR_STREAM),
-					stringColorize("ERROR", COLOR_ERROR), stringColorize(k, COLOR_HIGHLIGHT), err)
+				log.Error("(webhook:fire) json.Unmarshall jobsMap", "job", k, "err", err)
 			} else {
-				fmt.Printf("[%s] (webhook:fire) job '%s' triggered: %s\n",
-					stringColorize(sub.stream.Name, COLOR_STREAM),
-					stringColorize(k, COLOR_HIGHLIGHT),
-					stringColorize(string(pipelineMap["triggered"]), COLOR_HIGHLIGHT))
+				log.Info("(webhook:fire) job triggered", "job", k, "triggered", string(pipelineMap["triggered"]))
 			}
 		}
 	}
+	return nil
 }
 
 func (webhook webhook) shouldFire(n *Notification, data []byte) bool {
@@ -223,36 +436,71 @@ func (webhook webhook) shouldFire(n *Notification, data []byte) bool {
 }
 
 func (webhook *webhook) filter(n *Notification, data []byte) bool {
+	for _, check := range webhook.filterChecks(n, data) {
+		if !check.passed {
+			logger.Debug("(webhook:filter) "+check.reason, "stream", webhook.Stream, "webhook", webhook.target(), "condition", check.condition)
+			return false
+		}
+	}
+	return true
+}
+
+// Each filter condition and whether the event meets it. ALL of them must pass for the
+// webhook to fire
+
+const filterMatchDisplay = 100 // chars of a matching node shown
+
+type filterCheck struct {
+	condition string
+	passed    bool
+	reason    string
+}
+
+func (webhook *webhook) filterChecks(n *Notification, data []byte) []filterCheck {
 	if webhook.Filter == nil {
-		return true
+		return nil
 	}
 
-	if webhook.Filter.Event != "" && webhook.Filter.Event != n.EventName {
-		return false
+	var checks []filterCheck
+	if webhook.Filter.Event != "" {
+		check := filterCheck{condition: "event = " + webhook.Filter.Event, passed: webhook.Filter.Event == n.EventName}
+		if check.passed {
+			check.reason = "event matches"
+		} else {
+			check.reason = "event is " + n.EventName
+		}
+		checks = append(checks, check)
 	}
 
-	for _, n := range webhook.Filter.Node {
-		name, nameOk := (*n)["name"]
-		value, valueOk := (*n)["value"]
+	for _, node := range webhook.Filter.Node {
+		name, nameOk := (*node)["name"]
+		value, valueOk := (*node)["value"]
 		if nameOk && valueOk {
-			reNode := regexp.MustCompile(fmt.Sprintf("<%s[^>]*>%s</%s>", name, value, name))
-			if subMatches := reNode.FindStringSubmatch(string(data)); subMatches == nil {
-				debugMsgf("[%s] (webhook:filter) did %s find node '%s' with value '%s'\n",
-					stringColorize(webhook.Stream, COLOR_STREAM),
-					stringColorize("NOT", COLOR_HI_RED),
-					stringColorize(name, COLOR_HIGHLIGHT), stringColorize(value, COLOR_HIGHLIGHT))
-				return false
+			check := filterCheck{condition: fmt.Sprintf("node %s = %s", name, value)}
+			reNode, err := regexp.Compile(fmt.Sprintf("<%s[^>]*>%s</%s>", name, value, name))
+			if err != nil {
+				check.reason = "invalid regexp: " + err.Error()
+			} else if match := reNode.Find(data); match != nil {
+				check.passed = true
+				check.reason = "found " + stringTruncate(string(match), filterMatchDisplay)
+			} else {
+				check.reason = "did NOT find node with value"
 			}
+			checks = append(checks, check)
 		}
 		if nameOk && !valueOk { // Node must be present, but value irrelevant
-			reNode := regexp.MustCompile(fmt.Sprintf("<%s[\\s>]+", name))
-			if subMatches := reNode.FindStringSubmatch(string(data)); subMatches == nil {
-				debugMsgf("[%s] (webhook:filter) did not find node '%s'\n",
-					stringColorize(webhook.Stream, COLOR_STREAM),
-					stringColorize("NOT", COLOR_HI_RED), stringColorize(name, COLOR_HIGHLIGHT))
-				return false
+			check := filterCheck{condition: "node " + name + " present"}
+			reNode, err := regexp.Compile(fmt.Sprintf("<%s[\\s>]+", name))
+			if err != nil {
+				check.reason = "invalid regexp: " + err.Error()
+			} else if reNode.Match(data) {
+				check.passed = true
+				check.reason = "found node"
+			} else {
+				check.reason = "did NOT find node"
 			}
+			checks = append(checks, check)
 		}
 	}
-	return true
+	return checks
 }
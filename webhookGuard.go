@@ -0,0 +1,404 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRateBurst        = 1
+	defaultBreakerFailures  = 5
+	defaultBreakerCooldown  = 30 * time.Second
+	defaultBreakerQueueSize = 100
+)
+
+// Per-target protection for webhook receivers. All webhooks posting to the same URL share
+// one guard, made up of an optional token-bucket rate limit and an optional circuit breaker
+
+type RateLimit struct {
+	Rate  float64 // requests per second
+	Burst int
+}
+
+type CircuitBreaker struct {
+	Failures  int           // consecutive failures before the breaker opens
+	Cooldown  time.Duration // time spent open before a half-open probe
+	Policy    string        // "queue" or "drop" deliveries while open
+	QueueSize int
+}
+
+func (r *RateLimit) validate() error {
+	if r.Rate <= 0 {
+		return fmt.Errorf("rateLimit rate must be greater than 0")
+	}
+	if r.Burst == 0 {
+		r.Burst = defaultRateBurst
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("rateLimit burst must be at least 1")
+	}
+	return nil
+}
+
+func (c *CircuitBreaker) validate() error {
+	if c.Failures == 0 {
+		c.Failures = defaultBreakerFailures
+	}
+	if c.Cooldown == 0 {
+		c.Cooldown = defaultBreakerCooldown
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = defaultBreakerQueueSize
+	}
+	c.Policy = strings.ToLower(c.Policy)
+	if c.Policy == "" {
+		c.Policy = "queue"
+	}
+	if c.Failures < 0 || c.Cooldown < 0 || c.QueueSize < 0 {
+		return fmt.Errorf("circuitBreaker failures, cooldown and queueSize must be positive")
+	}
+	if c.Policy != "queue" && c.Policy != "drop" {
+		return fmt.Errorf("unknown circuitBreaker policy '%s' (expected queue or drop)", c.Policy)
+	}
+	return nil
+}
+
+//**********
+// Token bucket
+//**********
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(r *RateLimit) *tokenBucket {
+	return &tokenBucket{rate: r.Rate, burst: float64(r.Burst), tokens: float64(r.Burst), last: time.Now()}
+}
+
+// Block until a token is available
+
+func (b *tokenBucket) wait() {
+	for {
+		delay := b.take(time.Now())
+		if delay == 0 {
+			return
+		}
+		time.Sleep(delay)
+	}
+}
+
+// Refill the bucket for the time since it was last used and take a token. If there isn't
+// one, returns how long until there will be
+
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+//**********
+// Circuit breaker
+//**********
+
+type BreakerState int
+
+const (
+	BREAKER_CLOSED BreakerState = iota
+	BREAKER_OPEN
+	BREAKER_HALF_OPEN
+)
+
+func (s BreakerState) String() string {
+	return [...]string{"closed", "open", "half-open"}[s]
+}
+
+type queuedDelivery struct {
+	hook *webhook
+	sub  streamSubscriber
+	body []byte
+}
+
+type targetGuard struct {
+	url       string
+	rateLimit *RateLimit
+	limiter   *tokenBucket
+	config    *CircuitBreaker
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	probing   bool
+	stopped   bool // no more probes, shutdown has stopped waiting for them
+	openedAt  time.Time
+	queue     []queuedDelivery
+}
+
+// Guards replaced by a reload are kept in retired until they've finished with their
+// queues, so shutdown can still report what they hold
+
+var targetGuards = struct {
+	sync.Mutex
+	byURL   map[string]*targetGuard
+	retired []*targetGuard
+}{byURL: make(map[string]*targetGuard)}
+
+// Find or create the shared guard for a webhook's URL. Within one config the first
+// webhook to register a URL determines its settings; claimed holds the guards registered
+// so far. A guard left over from an earlier config is replaced if its settings changed,
+// the old one finishing whatever it has queued
+
+func targetGuardFor(hook *webhook, claimed map[*targetGuard]bool) *targetGuard {
+	targetGuards.Lock()
+	defer targetGuards.Unlock()
+
+	if g, found := targetGuards.byURL[hook.target()]; found {
+		switch {
+		case g.sameSettings(hook):
+			claimed[g] = true
+			return g
+		case claimed[g]:
+			logger.Warn("webhook target shared by several webhooks, using the first webhook's rateLimit/circuitBreaker settings", "webhook", hook.target())
+			return g
+		}
+		logger.Info("(webhook:breaker) rateLimit/circuitBreaker settings changed", "webhook", hook.target())
+		retireTargetGuard(g)
+	}
+
+	g := &targetGuard{url: hook.target(), rateLimit: hook.RateLimit, config: hook.CircuitBreaker}
+	if hook.RateLimit != nil {
+		g.limiter = newTokenBucket(hook.RateLimit)
+	}
+	targetGuards.byURL[hook.target()] = g
+	claimed[g] = true
+	return g
+}
+
+// Called with targetGuards locked
+
+func retireTargetGuard(old *targetGuard) {
+	retired := []*targetGuard{old}
+	for _, g := range targetGuards.retired {
+		g.mu.Lock()
+		if g.state != BREAKER_CLOSED || len(g.queue) > 0 {
+			retired = append(retired, g)
+		}
+		g.mu.Unlock()
+	}
+	targetGuards.retired = retired
+}
+
+// Every guard that may still hold queued deliveries, current or retired
+
+func allTargetGuards() []*targetGuard {
+	targetGuards.Lock()
+	defer targetGuards.Unlock()
+
+	guards := append([]*targetGuard{}, targetGuards.retired...)
+	for _, g := range targetGuards.byURL {
+		guards = append(guards, g)
+	}
+	return guards
+}
+
+func (g *targetGuard) sameSettings(hook *webhook) bool {
+	return sameValue(g.rateLimit, hook.RateLimit) && sameValue(g.config, hook.CircuitBreaker)
+}
+
+func sameValue[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Deliver through the guard: wait for the rate limit, then check the breaker
+
+func (g *targetGuard) submit(hook *webhook, sub streamSubscriber, body []byte) {
+	if g.config != nil && !g.allow() {
+		g.reject(queuedDelivery{hook: hook, sub: sub, body: body})
+		return
+	}
+	if g.limiter != nil {
+		g.limiter.wait()
+	}
+	err := hook.deliver(sub, body)
+	if g.config != nil {
+		g.record(err == nil)
+	}
+}
+
+func (g *targetGuard) allow() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch g.state {
+	case BREAKER_OPEN:
+		if time.Since(g.openedAt) < g.config.Cooldown {
+			return false
+		}
+		g.transition(BREAKER_HALF_OPEN)
+		g.probing = true
+		return true
+	case BREAKER_HALF_OPEN:
+		if g.probing {
+			return false
+		}
+		g.probing = true
+		return true
+	}
+	return true
+}
+
+func (g *targetGuard) record(success bool) {
+	g.mu.Lock()
+
+	var drain []queuedDelivery
+	switch {
+	case success && g.state != BREAKER_CLOSED:
+		g.transition(BREAKER_CLOSED)
+		drain = g.queue
+		g.queue = nil
+	case success:
+	case g.state == BREAKER_HALF_OPEN:
+		g.open()
+	default:
+		g.failures++
+		if g.state == BREAKER_CLOSED && g.failures >= g.config.Failures {
+			g.open()
+		}
+	}
+	if success {
+		g.failures = 0
+	}
+	g.probing = false
+	g.mu.Unlock()
+
+	// Replay anything held while the breaker was open, oldest first
+
+	if count := len(drain); count > 0 {
+		logger.Info("(webhook:breaker) replaying queued events", "webhook", g.url, "count", count)
+		inflight.start()
+		go func() {
+			defer inflight.finish()
+			for _, q := range drain {
+				g.submit(q.hook, q.sub, q.body)
+				q.sub.marks.release()
+			}
+		}()
+	}
+}
+
+// Called with the lock held
+
+func (g *targetGuard) open() {
+	g.transition(BREAKER_OPEN)
+	g.openedAt = time.Now()
+
+	// Without a probe the queue would never drain if no new events arrive, so schedule one
+
+	if g.config.Policy == "queue" {
+		time.AfterFunc(g.config.Cooldown, g.probe)
+	}
+}
+
+// The probe is the oldest queued delivery. If it fails it goes back at the head of the
+// queue, and the breaker reopens to try again after the next cooldown. It's in-flight
+// work like any other delivery, so shutdown waits for it
+
+func (g *targetGuard) probe() {
+	g.mu.Lock()
+	if g.stopped || g.state != BREAKER_OPEN || len(g.queue) == 0 {
+		g.mu.Unlock()
+		return
+	}
+	q := g.queue[0]
+	g.queue = g.queue[1:]
+	inflight.start()
+	g.mu.Unlock()
+	defer inflight.finish()
+
+	if !g.allow() {
+		g.requeue(q)
+		return
+	}
+	if g.limiter != nil {
+		g.limiter.wait()
+	}
+	err := q.hook.deliver(q.sub, q.body)
+	if err != nil {
+		g.requeue(q)
+	} else {
+		q.sub.marks.release()
+	}
+	g.record(err == nil)
+}
+
+// Stop probing at shutdown; whatever is still queued is reported as abandoned
+
+func (g *targetGuard) stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stopped = true
+}
+
+func (g *targetGuard) requeue(q queuedDelivery) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.queue = append([]queuedDelivery{q}, g.queue...)
+}
+
+func (g *targetGuard) reject(q queuedDelivery) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.config.Policy == "drop" {
+		logger.Warn("(webhook:breaker) delivery dropped", "stream", q.sub.stream.label(), "webhook", g.url, "circuit", g.state.String())
+		return
+	}
+
+	// A queued delivery keeps its events from being checkpointed until it's made or dropped
+
+	if len(g.queue) >= g.config.QueueSize {
+		g.queue[0].sub.marks.release()
+		g.queue = g.queue[1:]
+		logger.Warn("(webhook:breaker) queue full, oldest delivery dropped", "stream", q.sub.stream.label(), "webhook", g.url)
+	}
+	q.sub.marks.hold()
+	g.queue = append(g.queue, q)
+	logger.Debug("(webhook:breaker) delivery queued", "stream", q.sub.stream.label(), "webhook", g.url,
+		"circuit", g.state.String(), "queued", len(g.queue))
+}
+
+// Called with the lock held
+
+func (g *targetGuard) transition(state BreakerState) {
+	if g.state == state {
+		return
+	}
+	log := logger.Info
+	if state == BREAKER_OPEN {
+		log = logger.Warn
+	}
+	log("(webhook:breaker) circuit state change", "webhook", g.url, "from", g.state.String(), "to", state.String())
+	g.state = state
+}
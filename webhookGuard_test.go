@@ -0,0 +1,283 @@
+/*
+Author:  Tim Thomas
+Created: 18-Oct-2026
+*/
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefill(t *testing.T) {
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		rate    float64
+		burst   float64
+		tokens  float64
+		elapsed time.Duration
+		delay   time.Duration // 0 when a token is taken
+		left    float64
+	}{
+		{"token available", 1, 1, 1, 0, 0, 0},
+		{"empty", 2, 1, 0, 0, 500 * time.Millisecond, 0},
+		{"part refilled", 2, 1, 0, 250 * time.Millisecond, 250 * time.Millisecond, 0.5},
+		{"refilled", 2, 1, 0, 500 * time.Millisecond, 0, 0},
+		{"refill capped at burst", 10, 3, 0, time.Minute, 0, 2},
+		{"burst used up", 1, 3, 0.5, 0, 500 * time.Millisecond, 0.5},
+	}
+	for _, test := range tests {
+		b := &tokenBucket{rate: test.rate, burst: test.burst, tokens: test.tokens, last: start}
+		if delay := b.take(start.Add(test.elapsed)); delay != test.delay {
+			t.Errorf("%s: delay %v, want %v", test.name, delay, test.delay)
+		}
+		if b.tokens != test.left {
+			t.Errorf("%s: %v tokens left, want %v", test.name, b.tokens, test.left)
+		}
+	}
+}
+
+// The breaker's state after each delivery outcome. cooldown stands for the cooldown
+// having passed since the breaker opened
+
+func TestBreakerTransitions(t *testing.T) {
+	type step struct {
+		op      string // allow, ok, fail or cooldown
+		allowed bool   // for allow
+		state   BreakerState
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"opens after consecutive failures", []step{
+			{"allow", true, BREAKER_CLOSED}, {"fail", false, BREAKER_CLOSED},
+			{"allow", true, BREAKER_CLOSED}, {"fail", false, BREAKER_OPEN},
+			{"allow", false, BREAKER_OPEN},
+		}},
+		{"success resets the failure count", []step{
+			{"fail", false, BREAKER_CLOSED}, {"ok", false, BREAKER_CLOSED},
+			{"fail", false, BREAKER_CLOSED}, {"ok", false, BREAKER_CLOSED},
+		}},
+		{"one probe after the cooldown, which closes it", []step{
+			{"fail", false, BREAKER_CLOSED}, {"fail", false, BREAKER_OPEN},
+			{"cooldown", false, BREAKER_OPEN},
+			{"allow", true, BREAKER_HALF_OPEN}, {"allow", false, BREAKER_HALF_OPEN},
+			{"ok", false, BREAKER_CLOSED}, {"allow", true, BREAKER_CLOSED},
+		}},
+		{"a failed probe reopens it", []step{
+			{"fail", false, BREAKER_CLOSED}, {"fail", false, BREAKER_OPEN},
+			{"cooldown", false, BREAKER_OPEN},
+			{"allow", true, BREAKER_HALF_OPEN}, {"fail", false, BREAKER_OPEN},
+			{"allow", false, BREAKER_OPEN},
+			{"cooldown", false, BREAKER_OPEN}, {"allow", true, BREAKER_HALF_OPEN},
+		}},
+	}
+	for _, test := range tests {
+		g := &targetGuard{url: "http://127.0.0.1:9/hook", config: &CircuitBreaker{Failures: 2, Cooldown: time.Hour, Policy: "drop"}}
+		for i, s := range test.steps {
+			switch s.op {
+			case "allow":
+				if allowed := g.allow(); allowed != s.allowed {
+					t.Errorf("%s: step %d allow() = %v, want %v", test.name, i+1, allowed, s.allowed)
+				}
+			case "ok", "fail":
+				g.record(s.op == "ok")
+			case "cooldown":
+				g.openedAt = g.openedAt.Add(-g.config.Cooldown)
+			}
+			if g.state != s.state {
+				t.Fatalf("%s: step %d (%s) state %s, want %s", test.name, i+1, s.op, g.state, s.state)
+			}
+		}
+	}
+}
+
+// A sink returning the next of its results for each delivery. If gate is set it signals
+// on it when a delivery starts, then waits on it before going ahead
+
+type scriptedSink struct {
+	mu      sync.Mutex
+	results []error
+	sent    []string
+	gate    chan struct{}
+}
+
+func (s *scriptedSink) send(sub streamSubscriber, body []byte) error {
+	if s.gate != nil {
+		s.gate <- struct{}{}
+		<-s.gate
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if len(s.results) > 0 {
+		err, s.results = s.results[0], s.results[1:]
+	}
+	if err == nil {
+		s.sent = append(s.sent, string(body))
+	}
+	return err
+}
+
+func (s *scriptedSink) delivered() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.sent...)
+}
+
+func guardTestHook(results ...error) (*webhook, *scriptedSink) {
+	s := &scriptedSink{results: results}
+	return &webhook{Stream: "NETCONF", sinkType: SINK_STDOUT, sink: s}, s
+}
+
+var errRefused = errors.New("connection refused")
+
+// While the breaker is open deliveries are queued up to the queue size or dropped. The
+// probe retries the oldest, going back to the head of the queue if it fails; once one
+// succeeds the rest are replayed in order
+
+func TestBreakerQueue(t *testing.T) {
+	sub := streamSubscriber{stream: &Stream{Name: "NETCONF", server: "nso1"}}
+	tests := []struct {
+		policy    string
+		queued    []string
+		delivered []string
+	}{
+		{"queue", []string{"2", "3"}, []string{"2", "3"}},
+		{"drop", nil, []string{}},
+	}
+	for _, test := range tests {
+		hook, sink := guardTestHook(errRefused, errRefused)
+		g := &targetGuard{url: "http://127.0.0.1:9/hook",
+			config: &CircuitBreaker{Failures: 1, Cooldown: time.Hour, Policy: test.policy, QueueSize: 2}}
+
+		// The first delivery fails and opens the breaker; of the next three the queue
+		// keeps the newest two
+
+		for _, body := range []string{"0", "1", "2", "3"} {
+			g.submit(hook, sub, []byte(body))
+		}
+		if g.state != BREAKER_OPEN {
+			t.Fatalf("%s: state %s, want open", test.policy, g.state)
+		}
+		if queued := queuedBodies(g); !reflect.DeepEqual(queued, test.queued) {
+			t.Fatalf("%s: queued %q, want %q", test.policy, queued, test.queued)
+		}
+
+		// A failed probe puts the delivery back and reopens the breaker, a successful one
+		// closes it and replays the rest
+
+		g.openedAt = g.openedAt.Add(-time.Hour)
+		g.probe()
+		if queued := queuedBodies(g); g.state != BREAKER_OPEN || !reflect.DeepEqual(queued, test.queued) {
+			t.Errorf("%s: after a failed probe state %s queued %q, want open and %q", test.policy, g.state, queued, test.queued)
+		}
+		g.openedAt = g.openedAt.Add(-time.Hour)
+		g.probe()
+		if inflight.wait(5*time.Second) != 0 {
+			t.Fatalf("%s: replay didn't finish", test.policy)
+		}
+		if delivered := sink.delivered(); !reflect.DeepEqual(delivered, test.delivered) || len(queuedBodies(g)) != 0 {
+			t.Errorf("%s: delivered %q, want %q", test.policy, delivered, test.delivered)
+		}
+		if test.policy == "queue" && g.state != BREAKER_CLOSED {
+			t.Errorf("%s: state %s after a successful probe, want closed", test.policy, g.state)
+		}
+	}
+}
+
+func queuedBodies(g *targetGuard) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var bodies []string
+	for _, q := range g.queue {
+		bodies = append(bodies, string(q.body))
+	}
+	return bodies
+}
+
+// Shutdown waits for a probe like any other delivery, and stopping the guard stops
+// further probes
+
+func TestBreakerProbeTracked(t *testing.T) {
+	sub := streamSubscriber{stream: &Stream{Name: "NETCONF", server: "nso1"}}
+	hook, sink := guardTestHook()
+	g := &targetGuard{url: "http://127.0.0.1:9/hook", config: &CircuitBreaker{Failures: 1, Cooldown: time.Hour, Policy: "queue", QueueSize: 2}}
+	g.state = BREAKER_OPEN
+	g.reject(queuedDelivery{hook: hook, sub: sub, body: []byte("1")})
+
+	sink.gate = make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.probe()
+	}()
+	<-sink.gate
+	if pending := inflight.wait(10 * time.Millisecond); pending != 1 {
+		t.Errorf("%d deliveries in flight during the probe, want 1", pending)
+	}
+	sink.gate <- struct{}{}
+	<-done
+	if pending := inflight.wait(5 * time.Second); pending != 0 {
+		t.Errorf("%d deliveries in flight after the probe, want none", pending)
+	}
+
+	g.state = BREAKER_OPEN
+	g.reject(queuedDelivery{hook: hook, sub: sub, body: []byte("2")})
+	g.stop()
+	g.probe()
+	if queued := queuedBodies(g); len(queued) != 1 {
+		t.Errorf("stopped guard probed, queued %q", queued)
+	}
+}
+
+// A reload that changes a target's settings keeps the old guard around while it still
+// has deliveries queued
+
+func TestTargetGuardRetired(t *testing.T) {
+	url := "http://127.0.0.1:9/retired"
+	hook := func(rate float64) *webhook {
+		return &webhook{Stream: "NETCONF", Url: url, RateLimit: &RateLimit{Rate: rate, Burst: 1},
+			CircuitBreaker: &CircuitBreaker{Failures: 1, Cooldown: time.Hour, Policy: "queue", QueueSize: 2}}
+	}
+	defer func() {
+		targetGuards.Lock()
+		delete(targetGuards.byURL, url)
+		targetGuards.retired = nil
+		targetGuards.Unlock()
+	}()
+
+	old := targetGuardFor(hook(1), map[*targetGuard]bool{})
+	old.state = BREAKER_OPEN
+	old.queue = []queuedDelivery{{body: []byte("1")}}
+	current := targetGuardFor(hook(2), map[*targetGuard]bool{})
+	if current == old {
+		t.Fatal("guard not replaced")
+	}
+
+	guards := allTargetGuards()
+	found := map[*targetGuard]bool{}
+	for _, g := range guards {
+		found[g] = true
+	}
+	if !found[old] || !found[current] {
+		t.Error("replaced guard with a queue not kept for shutdown")
+	}
+
+	// Once it has drained it's forgotten at the next replacement
+
+	old.state, old.queue = BREAKER_CLOSED, nil
+	targetGuardFor(hook(3), map[*targetGuard]bool{})
+	targetGuards.Lock()
+	retired := targetGuards.retired
+	targetGuards.Unlock()
+	if len(retired) != 1 || retired[0] != current {
+		t.Errorf("retired guards %v, want only the one just replaced", retired)
+	}
+}